@@ -0,0 +1,122 @@
+package opentsdb
+
+import "errors"
+
+// Node - a node from the expression abstract syntax tree
+type Node interface {
+	// Function - returns the expression function name of the node
+	Function() string
+
+	// Children - returns the nodes nested inside this node
+	Children() []Node
+
+	// String - writes the node and its children as an expression
+	String() string
+
+	// lower - fills the TSDB query struct with the node values, returning the query relative
+	lower(tsdb *Expression) (string, error)
+}
+
+// ParseAST - parses a timeseries query expression and returns its abstract syntax tree, query pipelines can be
+// combined with the + - * / operators, a unary minus, numbers and the sum, scale, abs and timeShift functions like
+// sum(merge(sum,query(a,null,1h)),merge(sum,query(b,null,1h)))*100
+func ParseAST(exp string) (Node, error) {
+
+	node, err := parseMath(exp, 0)
+	if err != nil {
+		var perr *ParseError
+		if errors.As(err, &perr) {
+			perr.locate(exp)
+		}
+		return nil, err
+	}
+
+	// the positions are kept for the errors found later while lowering the tree
+	Walk(node, func(n Node) bool {
+		if p, ok := n.(positioned); ok {
+			p.locate(exp)
+		}
+		return true
+	})
+
+	return node, nil
+}
+
+// LowerAST - fills a TSDB query struct with the values from an abstract syntax tree
+func LowerAST(node Node, tsdb *Expression) (relative string, err error) {
+	if node == nil {
+		return stringsEmpty, errors.New("empty expression tree")
+	}
+	return node.lower(tsdb)
+}
+
+// Walk - visits the tree in depth-first order, the children of a node are skipped when fn returns false
+func Walk(node Node, fn func(Node) bool) {
+	if node == nil || !fn(node) {
+		return
+	}
+	for _, child := range node.Children() {
+		Walk(child, fn)
+	}
+}
+
+// functionOperations - the operation added to the expression order by each expression function
+var functionOperations = map[string]string{
+	"query":      "query",
+	"merge":      "aggregation",
+	"downsample": "downsample",
+	"groupBy":    "groupBy",
+	"rate":       "rate",
+	"filter":     "filterValue",
+	"topN":       "topN",
+	"bottomN":    "topN",
+}
+
+// position - the offset of the node function name in the expression, with its line and column
+type position struct {
+	offset int
+	line   int
+	column int
+}
+
+func (p *position) setOffset(offset int) {
+	p.offset = offset
+}
+
+func (p *position) locate(exp string) {
+	p.line, p.column = lineColumn(exp, p.offset)
+}
+
+// positioned - a node keeping the position of its function name
+type positioned interface {
+	setOffset(offset int)
+	locate(exp string)
+}
+
+// newDuplicateError - the error of a function whose operation is already in the query, at the position
+// of the function, expecting the functions whose operations are not there yet
+func newDuplicateError(node Node, pos position, tsdb *Expression, format string, args ...interface{}) *ParseError {
+
+	expected := []string{}
+
+	for _, f := range expressionFunctions {
+		if !hasOperation(tsdb, functionOperations[f]) {
+			expected = append(expected, f)
+		}
+	}
+
+	err := newParseError(pos.offset, node.Function(), expected, format, args...)
+	err.Line, err.Column = pos.line, pos.column
+
+	return err
+}
+
+// hasOperation - checks if an operation was already added to the expression order
+func hasOperation(tsdb *Expression, operation string) bool {
+	for _, oper := range tsdb.Order {
+		if oper == operation {
+			return true
+		}
+	}
+	return false
+}
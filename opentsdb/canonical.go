@@ -0,0 +1,172 @@
+package opentsdb
+
+import (
+	"sort"
+	"strings"
+)
+
+// Normalize - returns a copy of the query with its expressions in the canonical form, which is the one written by
+// CompileExpression and read back by ParseExpression:
+//   - the operations not configured are removed from the order array, which gets the default order when empty
+//   - the downsample always has a fill policy and its timezone, inherited from the query, is only kept for calendar intervals
+//   - the rate options are cleared when there is no rate and the filter value is written without spaces or redundant parentheses
+//   - the tags are converted to group by filters like OpenTSDB does, literal_or ones unless the value is a wildcard
+//   - the filters have no duplicates, the query ones come first and then the group by ones, sorted by tag key and value
+func (query *Query) Normalize() Query {
+
+	normalized := *query
+
+	normalized.Queries = make([]Expression, len(query.Queries))
+
+	for i, exp := range query.Queries {
+		normalized.Queries[i] = query.normalizeExpression(exp)
+	}
+
+	return normalized
+}
+
+func (query *Query) normalizeExpression(exp Expression) Expression {
+
+	normalized := Expression{
+		Metric: exp.Metric,
+		Tags:   map[string]string{},
+	}
+
+	order := exp.Order
+	if len(order) == 0 {
+		order = exp.defaultOrder()
+	}
+
+	for _, operation := range order {
+
+		switch operation {
+		case "aggregation":
+			normalized.Aggregator = exp.Aggregator
+		case "downsample":
+			if exp.Downsample == stringsEmpty {
+				continue
+			}
+			normalized.Downsample = normalizeDownsample(exp.Downsample)
+			if strings.HasSuffix(strings.SplitN(exp.Downsample, "-", 2)[0], calendarSuffix) {
+				normalized.Timezone = exp.Timezone
+				if normalized.Timezone == stringsEmpty {
+					normalized.Timezone = query.Timezone
+				}
+			}
+		case "rate":
+			if !exp.Rate {
+				continue
+			}
+			normalized.Rate = true
+			normalized.RateOptions = exp.RateOptions
+			if exp.RateOptions.CounterMax != nil {
+				counterMax := *exp.RateOptions.CounterMax
+				normalized.RateOptions.CounterMax = &counterMax
+			}
+		case "filterValue":
+			if exp.FilterValue == stringsEmpty {
+				continue
+			}
+			normalized.FilterValue = exp.FilterValue
+			if matcher, err := CompileFilterValue(exp.FilterValue); err == nil {
+				normalized.FilterValue = matcher.String()
+			}
+		case "topN":
+			if exp.TopN == nil {
+				continue
+			}
+			topN := *exp.TopN
+			normalized.TopN = &topN
+		default:
+			continue
+		}
+
+		normalized.Order = append(normalized.Order, operation)
+	}
+
+	filters := expressionFilters(exp)
+
+	if len(filters) > 0 {
+		normalized.Filters = sortTagFilters(filters)
+	}
+
+	return normalized
+}
+
+// normalizeDownsample - writes the downsample with its fill policy, none when not set
+func normalizeDownsample(downsample string) string {
+
+	info := strings.SplitN(downsample, "-", 3)
+
+	if len(info) < 2 {
+		return downsample
+	}
+
+	if len(info) == 2 {
+		info = append(info, FillNone)
+	}
+
+	if fill, err := parseFillPolicy(info[2]); err == nil {
+		info[2] = fill.String()
+	}
+
+	return strings.Join(info, "-")
+}
+
+// defaultOrder - returns the order of the configured operations used when the order array is empty
+func (exp Expression) defaultOrder() []string {
+
+	order := []string{}
+
+	if exp.FilterValue != stringsEmpty {
+		order = append(order, "filterValue")
+	}
+
+	if exp.Downsample != stringsEmpty {
+		order = append(order, "downsample")
+	}
+
+	order = append(order, "aggregation")
+
+	if exp.Rate {
+		order = append(order, "rate")
+	}
+
+	if exp.TopN != nil {
+		order = append(order, "topN")
+	}
+
+	return order
+}
+
+// expressionFilters - returns the expression filters with its tags converted to group by filters,
+// wildcard when the tag value has a * and literal_or otherwise
+func expressionFilters(exp Expression) []Filter {
+
+	filters := append([]Filter{}, exp.Filters...)
+
+	tagks := make([]string, 0, len(exp.Tags))
+	for tagk := range exp.Tags {
+		tagks = append(tagks, tagk)
+	}
+
+	sort.Strings(tagks)
+
+	for _, tagk := range tagks {
+
+		filter := Filter{
+			Ftype:   "literal_or",
+			Tagk:    tagk,
+			Filter:  exp.Tags[tagk],
+			GroupBy: true,
+		}
+
+		if strings.Contains(filter.Filter, "*") {
+			filter.Ftype = "wildcard"
+		}
+
+		filters = append(filters, filter)
+	}
+
+	return filters
+}
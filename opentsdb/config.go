@@ -6,15 +6,9 @@ type FilterInfo struct {
 	Description string `json:"description"`
 }
 
-// GetAggregators - returns the implemented aggregators
+// GetAggregators - returns the implemented and registered aggregators
 func GetAggregators() []string {
-	return []string{
-		"avg",
-		"count",
-		"min",
-		"max",
-		"sum",
-	}
+	return aggregatorRegistry.list()
 }
 
 // GetFilters - returns the implemented filters
@@ -49,23 +43,20 @@ func GetFiltersFull() map[string]FilterInfo {
 	}
 }
 
-// GetDownsamplers - returns the implemented downsamplers
+// GetDownsamplers - returns the implemented and registered downsamplers
 func GetDownsamplers() []string {
-	return []string{
-		"avg",
-		"count",
-		"min",
-		"max",
-		"sum",
-	}
+	return downsamplerRegistry.list()
 }
 
-// GetDownsampleFillers - returns the downsample fillers
+// GetDownsampleFillers - returns the downsample fillers, the constant filler is used with its value like constant:0
 func GetDownsampleFillers() []string {
 	return []string{
 		"none",
 		"nan",
 		"null",
 		"zero",
+		"previous",
+		"linear",
+		"constant",
 	}
 }
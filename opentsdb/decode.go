@@ -0,0 +1,285 @@
+package opentsdb
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/buger/jsonparser"
+)
+
+const (
+	pointMetricParam    string = "metric"
+	pointTimestampParam string = "timestamp"
+	pointValueParam     string = "value"
+	pointTextParam      string = "text"
+	pointTagsParam      string = "tags"
+	pointTTLParam       string = "ttl"
+	pointKeysetParam    string = "keyset"
+	pointTagNameParam   string = "name"
+	pointTagValueParam  string = "value"
+)
+
+// Parse - parses an OpenTSDB /api/put JSON body holding a single point or an array of points,
+// the tags can be an object like {"host":"a"} or an array of name and value objects and
+// the ksid and ttl tags are set as the point keyset and TTL
+func (points *Points) Parse(data []byte) error {
+
+	value, dataType, offset, err := jsonparser.Get(data)
+	if err != nil {
+		return &DecodeError{Index: 0, Message: err.Error()}
+	}
+
+	switch dataType {
+	case jsonparser.Object:
+
+		point := &Point{}
+
+		if err := point.parse(value); err != nil {
+			err.Offset += offset - len(value)
+			return err
+		}
+
+		*points = Points{point}
+
+		return nil
+
+	case jsonparser.Array:
+
+		parsed := Points{}
+
+		var decodeErr *DecodeError
+
+		_, err := jsonparser.ArrayEach(data, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
+
+			if decodeErr != nil {
+				return
+			}
+
+			if err != nil || dataType != jsonparser.Object {
+				decodeErr = &DecodeError{Index: len(parsed), Offset: offset, Message: "point must be a JSON object"}
+				return
+			}
+
+			point := &Point{}
+
+			if perr := point.parse(value); perr != nil {
+				perr.Index = len(parsed)
+				perr.Offset += offset
+				decodeErr = perr
+				return
+			}
+
+			parsed = append(parsed, point)
+		})
+
+		if decodeErr != nil {
+			return decodeErr
+		}
+
+		if err != nil {
+			return &DecodeError{Index: len(parsed), Message: err.Error()}
+		}
+
+		*points = parsed
+
+		return nil
+	}
+
+	return &DecodeError{Index: 0, Offset: valueOffset(offset, value, dataType), Message: "expected a JSON object or array"}
+}
+
+// parse - parses a point JSON object, the error offsets are relative to the object
+func (point *Point) parse(data []byte) *DecodeError {
+
+	var decodeErr *DecodeError
+
+	err := jsonparser.ObjectEach(data, func(key, value []byte, dataType jsonparser.ValueType, offset int) error {
+
+		field := string(key)
+
+		var err error
+
+		switch field {
+		case pointMetricParam:
+			point.Metric, err = parsePointString(value, dataType)
+		case pointTimestampParam:
+			if dataType != jsonparser.Number {
+				err = errPointNumber
+				break
+			}
+			point.Timestamp, err = jsonparser.ParseInt(value)
+		case pointValueParam:
+			err = point.parseValue(value, dataType)
+		case pointTextParam:
+			point.Text, err = parsePointString(value, dataType)
+		case pointKeysetParam:
+			point.Keyset, err = parsePointString(value, dataType)
+		case pointTTLParam:
+			if dataType != jsonparser.Number {
+				err = errPointNumber
+				break
+			}
+			var ttl int64
+			ttl, err = jsonparser.ParseInt(value)
+			point.TTL = int(ttl)
+		case pointTagsParam:
+			field, err = point.parseTags(value, dataType)
+		}
+
+		if err != nil {
+			decodeErr = &DecodeError{Field: field, Offset: valueOffset(offset, value, dataType), Message: err.Error()}
+			return err
+		}
+
+		return nil
+	})
+
+	if decodeErr != nil {
+		return decodeErr
+	}
+
+	if err != nil {
+		return &DecodeError{Message: err.Error()}
+	}
+
+	return nil
+}
+
+func (point *Point) parseValue(value []byte, dataType jsonparser.ValueType) error {
+
+	var v float64
+	var err error
+
+	switch dataType {
+	case jsonparser.Null:
+		point.Value = nil
+		return nil
+	case jsonparser.Number:
+		v, err = jsonparser.ParseFloat(value)
+	case jsonparser.String:
+		v, err = strconv.ParseFloat(string(value), 64)
+	default:
+		return errPointNumber
+	}
+
+	if err != nil {
+		return errPointNumber
+	}
+
+	point.Value = &v
+
+	return nil
+}
+
+// parseTags - parses the tags object or array, returning the path of the field in error
+func (point *Point) parseTags(value []byte, dataType jsonparser.ValueType) (string, error) {
+
+	var field string
+
+	addTag := func(name, value string) error {
+
+		switch name {
+		case putKSIDTag:
+			point.Keyset = value
+		case putTTLTag:
+			ttl, err := strconv.Atoi(value)
+			if err != nil {
+				return errors.New("ttl must be an integer")
+			}
+			point.TTL = ttl
+		default:
+			point.Tags = append(point.Tags, Tag{Name: name, Value: value})
+		}
+
+		return nil
+	}
+
+	switch dataType {
+	case jsonparser.Null:
+		return pointTagsParam, nil
+
+	case jsonparser.Object:
+
+		err := jsonparser.ObjectEach(value, func(key, tagValue []byte, dataType jsonparser.ValueType, offset int) error {
+
+			name, err := jsonparser.ParseString(key)
+			if err != nil {
+				return err
+			}
+
+			field = fmt.Sprintf("%s.%s", pointTagsParam, name)
+
+			v, err := parsePointString(tagValue, dataType)
+			if err != nil {
+				return err
+			}
+
+			return addTag(name, v)
+		})
+
+		return field, err
+
+	case jsonparser.Array:
+
+		var tagErr error
+
+		i := 0
+
+		_, err := jsonparser.ArrayEach(value, func(tag []byte, dataType jsonparser.ValueType, offset int, err error) {
+
+			if tagErr != nil {
+				return
+			}
+
+			field = fmt.Sprintf("%s[%d]", pointTagsParam, i)
+			i++
+
+			if dataType != jsonparser.Object {
+				tagErr = errors.New("tag must be a JSON object")
+				return
+			}
+
+			name, err := jsonparser.GetString(tag, pointTagNameParam)
+			if err != nil {
+				tagErr = errors.New("tag name must be a string")
+				return
+			}
+
+			v, err := jsonparser.GetString(tag, pointTagValueParam)
+			if err != nil {
+				tagErr = errors.New("tag value must be a string")
+				return
+			}
+
+			tagErr = addTag(name, v)
+		})
+
+		if tagErr != nil {
+			return field, tagErr
+		}
+
+		return pointTagsParam, err
+	}
+
+	return pointTagsParam, errors.New("tags must be a JSON object or array")
+}
+
+func parsePointString(value []byte, dataType jsonparser.ValueType) (string, error) {
+
+	if dataType != jsonparser.String {
+		return stringsEmpty, errPointString
+	}
+
+	return jsonparser.ParseString(value)
+}
+
+// valueOffset - returns where a value starts given the offset right after it
+func valueOffset(end int, value []byte, dataType jsonparser.ValueType) int {
+
+	if dataType == jsonparser.String {
+		return end - len(value) - 2
+	}
+
+	return end - len(value)
+}
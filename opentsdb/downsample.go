@@ -3,37 +3,304 @@ package opentsdb
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 )
 
-func parseDownsample(exp string, tsdb *Expression) (string, error) {
+// calendarSuffix - the suffix of downsample intervals aligned to the calendar, like 1dc
+const calendarSuffix string = "c"
 
-	params := parseParams(string(exp[10:]))
+// DownsampleNode - the downsample(interval,downsampler,fill,[timezone,]expression) function
+type DownsampleNode struct {
+	position
 
-	if len(params) != 4 {
-		return stringsEmpty, fmt.Errorf("downsample expects 4 parameters but found %d: %v", len(params), params)
+	Interval    string
+	Downsampler string
+	Fill        string
+	Timezone    string
+	Child       Node
+}
+
+// Function - returns the expression function name of the node
+func (n *DownsampleNode) Function() string {
+	return "downsample"
+}
+
+// Children - returns the nodes nested inside this node
+func (n *DownsampleNode) Children() []Node {
+	return []Node{n.Child}
+}
+
+// String - writes the node and its children as an expression
+func (n *DownsampleNode) String() string {
+	return writeDownsample(n.Child.String(), fmt.Sprintf("%s-%s-%s", n.Interval, n.Downsampler, n.Fill), n.Timezone)
+}
+
+func (n *DownsampleNode) lower(tsdb *Expression) (string, error) {
+
+	relative, err := n.Child.lower(tsdb)
+	if err != nil {
+		return relative, err
+	}
+
+	if hasOperation(tsdb, "downsample") {
+		return stringsEmpty, newDuplicateError(n, n.position, tsdb, "found more than one 'downsample' function")
+	}
+
+	tsdb.Downsample = fmt.Sprintf("%s-%s-%s", n.Interval, n.Downsampler, n.Fill)
+
+	if n.Timezone != stringsEmpty {
+		tsdb.Timezone = n.Timezone
+	}
+
+	tsdb.Order = append(tsdb.Order, "downsample")
+
+	return relative, nil
+}
+
+func parseDownsample(exp string, offset int) (Node, error) {
+
+	params, err := parseArgs(exp, offset)
+	if err != nil {
+		return nil, err
 	}
 
-	tsdb.Downsample = fmt.Sprintf("%s-%s-%s", params[0], params[1], params[2])
+	node := &DownsampleNode{}
+
+	if len(params) == 5 {
 
-	for _, oper := range tsdb.Order {
-		if oper == "downsample" {
-			return stringsEmpty, errors.New("found more than one 'downsample' function")
+		node.Timezone = params[3].atom()
+
+		if _, err := time.LoadLocation(node.Timezone); err != nil {
+			return nil, newParseError(params[3].offset, params[3].value, []string{"<timezone>"}, "unknown timezone %s", node.Timezone)
 		}
+
+		params = append(params[:3], params[4])
+
+	} else if err := checkParams("downsample", params, 4); err != nil {
+		return nil, err
 	}
 
-	tsdb.Order = append([]string{"downsample"}, tsdb.Order...)
+	if _, err := parseDownsampleInterval(params[0].atom()); err != nil {
+		return nil, newParseError(params[0].offset, params[0].value, []string{"<interval>"}, "invalid downsample interval: %s", err)
+	}
+
+	if err := checkParamValue(params[1], GetDownsamplers(), "downsampler"); err != nil {
+		return nil, err
+	}
+
+	fill, err := parseFillPolicy(params[2].atom())
+	if err != nil {
+		return nil, newParseError(params[2].offset, params[2].value, GetDownsampleFillers(), "invalid downsample fill: %s", err)
+	}
+
+	node.Child, err = parseExpression(params[3].value, params[3].offset)
+	if err != nil {
+		return nil, err
+	}
+
+	node.Interval = params[0].atom()
+	node.Downsampler = params[1].atom()
+	node.Fill = fill.String()
 
-	return params[3], nil
+	return node, nil
 }
 
-func writeDownsample(exp, dsInfo string) string {
+// writeDownsample - writes the downsample function, the timezone is only written for calendar intervals
+func writeDownsample(exp, dsInfo, timezone string) string {
 	if dsInfo != stringsEmpty {
-		info := strings.Split(dsInfo, "-")
+		info := strings.SplitN(dsInfo, "-", 3)
 		if len(info) == 2 {
 			info = append(info, "none")
 		}
+		if timezone != stringsEmpty && strings.HasSuffix(info[0], calendarSuffix) {
+			return fmt.Sprintf("downsample(%s,%s,%s,%s,%s)", info[0], info[1], info[2], timezone, exp)
+		}
 		exp = fmt.Sprintf("downsample(%s,%s,%s,%s)", info[0], info[1], info[2], exp)
 	}
 	return exp
 }
+
+// downsampleInterval - a parsed downsample interval like 5m or the calendar aligned 1dc
+type downsampleInterval struct {
+	n        int
+	unit     string
+	calendar bool
+}
+
+// parseDownsampleInterval - parses a downsample interval, days, weeks, months and years have fixed
+// lengths of 1, 7, 30 and 365 days unless the interval ends with c to be aligned to the calendar
+func parseDownsampleInterval(s string) (downsampleInterval, error) {
+
+	di := downsampleInterval{}
+
+	if strings.HasSuffix(s, calendarSuffix) {
+		di.calendar = true
+		s = s[:len(s)-len(calendarSuffix)]
+	}
+
+	if err := (&Query{}).checkDuration(s); err != nil {
+		return di, errors.New(err.Message)
+	}
+
+	di.unit = s[len(s)-1:]
+	if strings.HasSuffix(s, "ms") {
+		di.unit = "ms"
+	}
+
+	n, err := strconv.Atoi(s[:len(s)-len(di.unit)])
+	if err != nil {
+		return di, err
+	}
+
+	if n < 1 {
+		return di, errors.New("interval needs to be bigger than 0")
+	}
+
+	di.n = n
+
+	return di, nil
+}
+
+// millis - returns the fixed length of the interval in milliseconds
+func (di downsampleInterval) millis() int64 {
+
+	var unit time.Duration
+
+	switch di.unit {
+	case "ms":
+		unit = time.Millisecond
+	case "s":
+		unit = time.Second
+	case "m":
+		unit = time.Minute
+	case "h":
+		unit = time.Hour
+	case "d":
+		unit = 24 * time.Hour
+	case "w":
+		unit = 7 * 24 * time.Hour
+	case "n":
+		unit = 30 * 24 * time.Hour
+	case "y":
+		unit = 365 * 24 * time.Hour
+	}
+
+	return int64(di.n) * int64(unit/time.Millisecond)
+}
+
+// bucketStart - returns the start of the bucket containing the time, fixed intervals are aligned to the epoch and
+// calendar intervals to the start of the unit in the time location, counting multiple units from the epoch
+func (di downsampleInterval) bucketStart(t time.Time) time.Time {
+
+	if !di.calendar {
+		ms := toMillis(t)
+		ms -= floorMod(ms, di.millis())
+		return time.Unix(0, ms*int64(time.Millisecond)).In(t.Location())
+	}
+
+	loc := t.Location()
+	year, month, day := t.Date()
+	n := int64(di.n)
+
+	switch di.unit {
+	case "d", "w":
+		days := time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Unix() / 86400
+		if di.unit == "w" {
+			// the epoch was on a thursday, weeks start on mondays
+			days -= floorMod(days+3, 7)
+			days -= 7 * floorMod((days+3)/7, n)
+		} else {
+			days -= floorMod(days, n)
+		}
+		y, m, d := time.Unix(days*86400, 0).UTC().Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, loc)
+	case "n":
+		months := int64(year-1970)*12 + int64(month-1)
+		months -= floorMod(months, n)
+		return time.Date(1970, time.Month(months+1), 1, 0, 0, 0, 0, loc)
+	case "y":
+		return time.Date(year-int(floorMod(int64(year), n)), time.January, 1, 0, 0, 0, 0, loc)
+	}
+
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, loc)
+	elapsed := toMillis(t) - toMillis(midnight)
+
+	return midnight.Add(time.Duration(elapsed-floorMod(elapsed, di.millis())) * time.Millisecond)
+}
+
+// nextBucketStart - returns the start of the bucket following the one starting at the time
+func (di downsampleInterval) nextBucketStart(start time.Time) time.Time {
+
+	if di.calendar {
+		switch di.unit {
+		case "d":
+			return start.AddDate(0, 0, di.n)
+		case "w":
+			return start.AddDate(0, 0, 7*di.n)
+		case "n":
+			return start.AddDate(0, di.n, 0)
+		case "y":
+			return start.AddDate(di.n, 0, 0)
+		}
+	}
+
+	return start.Add(time.Duration(di.millis()) * time.Millisecond)
+}
+
+func floorMod(a, b int64) int64 {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
+
+// downsampleSeries - reduces the points of each interval bucket into one point at the bucket start,
+// calendar intervals are aligned in the location
+func downsampleSeries(points DataPoints, dsInfo string, loc *time.Location) (DataPoints, error) {
+
+	info := strings.SplitN(dsInfo, "-", 3)
+
+	if len(info) < 2 {
+		return nil, errors.New("invalid downsample format")
+	}
+
+	interval, err := parseDownsampleInterval(info[0])
+	if err != nil {
+		return nil, err
+	}
+
+	aggr, ok := GetDownsampler(info[1])
+	if !ok {
+		return nil, fmt.Errorf("invalid downsample %s", info[1])
+	}
+
+	downsampled := DataPoints{}
+	values := []float64{}
+
+	var bucket, next int64
+
+	for _, p := range points {
+
+		if len(values) > 0 && p.Timestamp >= next {
+			downsampled = append(downsampled, DataPoint{Timestamp: bucket, Value: aggr.Reduce(values)})
+			values = values[:0]
+		}
+
+		if len(values) == 0 {
+			start := interval.bucketStart(time.Unix(0, p.Timestamp*int64(time.Millisecond)).In(loc))
+			bucket = toMillis(start)
+			next = toMillis(interval.nextBucketStart(start))
+		}
+
+		values = append(values, p.Value)
+	}
+
+	if len(values) > 0 {
+		downsampled = append(downsampled, DataPoint{Timestamp: bucket, Value: aggr.Reduce(values)})
+	}
+
+	return downsampled, nil
+}
@@ -0,0 +1,191 @@
+package opentsdb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ParseError - an error found while parsing an expression, with the position of the offending token
+type ParseError struct {
+	Offset   int      `json:"offset"`
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+	Token    string   `json:"token"`
+	Expected []string `json:"expected,omitempty"`
+	Message  string   `json:"message"`
+}
+
+func newParseError(offset int, token string, expected []string, format string, args ...interface{}) *ParseError {
+	return &ParseError{
+		Offset:   offset,
+		Token:    token,
+		Expected: expected,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+// Error - returns the error message with its position
+func (e *ParseError) Error() string {
+
+	msg := fmt.Sprintf("%s at line %d, column %d", e.Message, e.Line, e.Column)
+
+	if e.Token != stringsEmpty {
+		msg = fmt.Sprintf("%s near '%s'", msg, e.Token)
+	}
+
+	if len(e.Expected) > 0 {
+		msg = fmt.Sprintf("%s, expected %s", msg, strings.Join(e.Expected, " or "))
+	}
+
+	return msg
+}
+
+// locate - sets the line and column (both starting at 1) of the error offset in the expression
+func (e *ParseError) locate(exp string) {
+
+	if e.Offset > len(exp) {
+		e.Offset = len(exp)
+	}
+
+	e.Line, e.Column = lineColumn(exp, e.Offset)
+}
+
+// lineColumn - returns the line and column (both starting at 1) of the offset in the expression
+func lineColumn(exp string, offset int) (int, int) {
+
+	if offset > len(exp) {
+		offset = len(exp)
+	}
+
+	before := exp[:offset]
+
+	return strings.Count(before, "\n") + 1, utf8.RuneCountInString(before[strings.LastIndexByte(before, '\n')+1:]) + 1
+}
+
+// The validation error codes
+const (
+	CodeRequired           string = "required"
+	CodeInvalidCharacters  string = "invalid_characters"
+	CodeInvalidDuration    string = "invalid_duration"
+	CodeUnknownAggregator  string = "unknown_aggregator"
+	CodeInvalidDownsample  string = "invalid_downsample"
+	CodeInvalidFill        string = "invalid_fill"
+	CodeInvalidRate        string = "invalid_rate"
+	CodeInvalidFilterValue string = "invalid_filter_value"
+	CodeInvalidOrder       string = "invalid_order"
+	CodeInvalidFilter      string = "invalid_filter"
+	CodeInvalidValue       string = "invalid_value"
+	CodeTooManyTags        string = "too_many_tags"
+	CodeInvalidTag         string = "invalid_tag"
+	CodeInvalidTTL         string = "invalid_ttl"
+	CodeInvalidTimezone    string = "invalid_timezone"
+	CodeInvalidTimeRange   string = "invalid_time_range"
+	CodeInvalidTopN        string = "invalid_top_n"
+)
+
+// ValidationError - a violation found while validating a query or a point
+type ValidationError struct {
+	Code    string      `json:"code"`
+	Path    string      `json:"path"`
+	Value   interface{} `json:"value,omitempty"`
+	Message string      `json:"message"`
+}
+
+func newValidationError(code string, value interface{}, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{
+		Code:    code,
+		Value:   value,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// Error - returns the error message prefixed by its JSON path
+func (e *ValidationError) Error() string {
+
+	if e.Path == stringsEmpty {
+		return e.Message
+	}
+
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationErrors - all violations found while validating a query
+type ValidationErrors []*ValidationError
+
+// Error - returns all error messages separated by a semicolon
+func (e ValidationErrors) Error() string {
+
+	msgs := make([]string, len(e))
+
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// validator - collects the violations found while validating
+type validator struct {
+	all  bool
+	errs ValidationErrors
+}
+
+// check - records the violation found at the path, returns true when the validation must go on
+func (v *validator) check(path string, err *ValidationError) bool {
+
+	if err == nil {
+		return true
+	}
+
+	err.Path = path
+	v.errs = append(v.errs, err)
+
+	return v.all
+}
+
+// PutLineError - an error found while parsing a field of a telnet style put line
+type PutLineError struct {
+	Field   string `json:"field"`
+	Offset  int    `json:"offset"`
+	Value   string `json:"value"`
+	Message string `json:"message"`
+}
+
+func newPutLineError(field string, offset int, value []byte, message string) *PutLineError {
+	return &PutLineError{
+		Field:   field,
+		Offset:  offset,
+		Value:   string(value),
+		Message: message,
+	}
+}
+
+// Error - returns the error message with the field and its offset
+func (e *PutLineError) Error() string {
+	return fmt.Sprintf("invalid %s '%s' at offset %d: %s", e.Field, e.Value, e.Offset, e.Message)
+}
+
+var (
+	errPointNumber = errors.New("must be a number")
+	errPointString = errors.New("must be a string")
+)
+
+// DecodeError - an error found while decoding a point from an OpenTSDB /api/put JSON body
+type DecodeError struct {
+	Index   int    `json:"index"`
+	Field   string `json:"field,omitempty"`
+	Offset  int    `json:"offset"`
+	Message string `json:"message"`
+}
+
+// Error - returns the error message with the point index and field
+func (e *DecodeError) Error() string {
+
+	if e.Field == stringsEmpty {
+		return fmt.Sprintf("point %d at offset %d: %s", e.Index, e.Offset, e.Message)
+	}
+
+	return fmt.Sprintf("point %d at offset %d: field %s %s", e.Index, e.Offset, e.Field, e.Message)
+}
@@ -0,0 +1,324 @@
+package opentsdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DataPoint - a value and its timestamp in milliseconds
+type DataPoint struct {
+	Timestamp int64
+	Value     float64
+}
+
+// DataPoints - data points serialized as the OpenTSDB dps object
+type DataPoints []DataPoint
+
+// Series - the points of a metric and tag set, sorted by timestamp
+type Series struct {
+	Metric        string
+	Tags          map[string]string
+	AggregateTags []string
+	Points        DataPoints
+}
+
+// QueryResult - the OpenTSDB compatible result from an evaluated expression
+type QueryResult struct {
+	Metric        string            `json:"metric"`
+	Tags          map[string]string `json:"tags"`
+	AggregateTags []string          `json:"aggregateTags"`
+	DPS           DataPoints        `json:"dps"`
+
+	// nullFill - the points were filled by the null fill policy, so NaN values are written as null
+	nullFill bool
+}
+
+// MarshalJSON - writes the result like OpenTSDB, NaN values are written as null when filled by the null fill policy
+func (result QueryResult) MarshalJSON() ([]byte, error) {
+
+	type queryResultAlias QueryResult
+
+	if !result.nullFill {
+		return json.Marshal(queryResultAlias(result))
+	}
+
+	return json.Marshal(struct {
+		queryResultAlias
+		DPS nullDataPoints `json:"dps"`
+	}{
+		queryResultAlias: queryResultAlias(result),
+		DPS:              nullDataPoints(result.DPS),
+	})
+}
+
+// MarshalJSON - writes the points as a JSON object keyed by timestamp, NaN and infinite values are written as strings
+func (dps DataPoints) MarshalJSON() ([]byte, error) {
+	return marshalPoints(dps, false), nil
+}
+
+// nullDataPoints - data points filled by the null fill policy
+type nullDataPoints DataPoints
+
+// MarshalJSON - writes the points like DataPoints, but NaN values are written as null
+func (dps nullDataPoints) MarshalJSON() ([]byte, error) {
+	return marshalPoints(DataPoints(dps), true), nil
+}
+
+func marshalPoints(dps DataPoints, nullNaN bool) []byte {
+
+	buffer := bytes.Buffer{}
+
+	buffer.WriteByte('{')
+
+	for i, dp := range dps {
+
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+
+		buffer.WriteByte('"')
+		buffer.WriteString(strconv.FormatInt(dp.Timestamp, 10))
+		buffer.WriteString(`":`)
+
+		if nullNaN && math.IsNaN(dp.Value) {
+			buffer.WriteString("null")
+		} else if math.IsNaN(dp.Value) || math.IsInf(dp.Value, 0) {
+			buffer.WriteString(strconv.Quote(strconv.FormatFloat(dp.Value, 'g', -1, 64)))
+		} else {
+			buffer.WriteString(strconv.FormatFloat(dp.Value, 'g', -1, 64))
+		}
+	}
+
+	buffer.WriteByte('}')
+
+	return buffer.Bytes()
+}
+
+// Evaluate - executes the operations of a validated expression over the series matching its metric, filters and tags,
+// the point timestamps must be in milliseconds and are returned in seconds unless msResolution is set,
+// calendar downsampling is aligned in the expression timezone or in UTC when not set. The downsample
+// fill policy fills the missing buckets between the first and the last point of the series.
+func Evaluate(exp Expression, series []Series, msResolution bool) ([]QueryResult, error) {
+	return EvaluateRange(exp, series, 0, 0, msResolution)
+}
+
+// EvaluateRange - works like Evaluate, but the downsample fill policy fills the missing buckets between
+// start and end, both epochs in milliseconds, a zero bound is replaced by the one of the span of the series
+// points and the buckets are not filled when a bound is zero and the series have no points
+func EvaluateRange(exp Expression, series []Series, start, end int64, msResolution bool) ([]QueryResult, error) {
+
+	if len(exp.Order) == 0 {
+		return nil, errors.New("expression has no operations in order array, validate it first")
+	}
+
+	filters := expressionFilters(exp)
+
+	matchers, err := CompileFilters(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	working := []Series{}
+
+	for _, s := range series {
+
+		if s.Metric != exp.Metric || !matchers.Match(s.Tags) {
+			continue
+		}
+
+		points := make(DataPoints, len(s.Points))
+		copy(points, s.Points)
+		sort.SliceStable(points, func(i, j int) bool { return points[i].Timestamp < points[j].Timestamp })
+
+		working = append(working, Series{
+			Metric: s.Metric,
+			Tags:   s.Tags,
+			Points: points,
+		})
+	}
+
+	loc := time.UTC
+
+	if exp.Timezone != stringsEmpty {
+		if loc, err = time.LoadLocation(exp.Timezone); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, operation := range exp.Order {
+
+		switch operation {
+		case "filterValue":
+			matcher, err := CompileFilterValue(exp.FilterValue)
+			if err != nil {
+				return nil, err
+			}
+			for i := range working {
+				working[i].Points = filterValueSeries(working[i].Points, matcher.Match)
+			}
+		case "downsample":
+			fillStart, fillEnd, fill := start, end, true
+			if start == 0 || end == 0 {
+				first, last, ok := seriesSpan(working)
+				if fillStart == 0 {
+					fillStart = first
+				}
+				if fillEnd == 0 {
+					fillEnd = last
+				}
+				fill = ok
+			}
+			for i := range working {
+				points, err := downsampleSeries(working[i].Points, exp.Downsample, loc)
+				if err != nil {
+					return nil, err
+				}
+				if !fill {
+					working[i].Points = points
+					continue
+				}
+				if working[i].Points, err = FillBuckets(points, exp.Downsample, fillStart, fillEnd, loc); err != nil {
+					return nil, err
+				}
+			}
+		case "aggregation":
+			merged, err := mergeSeries(working, exp.Aggregator, groupByTags(filters))
+			if err != nil {
+				return nil, err
+			}
+			working = merged
+		case "rate":
+			for i := range working {
+				if working[i].Points, err = RateSeries(working[i].Points, exp.RateOptions); err != nil {
+					return nil, err
+				}
+			}
+		case "topN":
+			if exp.TopN == nil {
+				return nil, errors.New("topN found in order array but not configured")
+			}
+			if working, err = topNSeries(working, *exp.TopN); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown operation %s in order array", operation)
+		}
+	}
+
+	results := make([]QueryResult, 0, len(working))
+
+	for _, s := range working {
+
+		dps := make(DataPoints, 0, len(s.Points))
+
+		for _, p := range s.Points {
+			if !msResolution {
+				p.Timestamp /= 1000
+			}
+			dps = append(dps, p)
+		}
+
+		aggregateTags := s.AggregateTags
+		if aggregateTags == nil {
+			aggregateTags = []string{}
+		}
+
+		results = append(results, QueryResult{
+			Metric:        s.Metric,
+			Tags:          s.Tags,
+			AggregateTags: aggregateTags,
+			DPS:           dps,
+			nullFill:      strings.HasSuffix(exp.Downsample, "-"+FillNull),
+		})
+	}
+
+	sortResults(results)
+
+	return results, nil
+}
+
+// sortResults - sorts the results by their tags
+func sortResults(results []QueryResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		return tagsKey(results[i].Tags) < tagsKey(results[j].Tags)
+	})
+}
+
+// seriesSpan - returns the first and the last timestamps of the series points, not ok when there are no points
+func seriesSpan(series []Series) (int64, int64, bool) {
+
+	start, end := int64(math.MaxInt64), int64(math.MinInt64)
+
+	for _, s := range series {
+		if len(s.Points) == 0 {
+			continue
+		}
+		if s.Points[0].Timestamp < start {
+			start = s.Points[0].Timestamp
+		}
+		if s.Points[len(s.Points)-1].Timestamp > end {
+			end = s.Points[len(s.Points)-1].Timestamp
+		}
+	}
+
+	if start > end {
+		return 0, 0, false
+	}
+
+	return start, end, true
+}
+
+// groupByTags - returns the distinct tag keys used to group the series
+func groupByTags(filters []Filter) []string {
+
+	tags := []string{}
+
+	for _, filter := range filters {
+		if !filter.GroupBy {
+			continue
+		}
+		found := false
+		for _, tag := range tags {
+			if tag == filter.Tagk {
+				found = true
+				break
+			}
+		}
+		if !found {
+			tags = append(tags, filter.Tagk)
+		}
+	}
+
+	sort.Strings(tags)
+
+	return tags
+}
+
+// tagsKey - returns a string identifying the tag set
+func tagsKey(tags map[string]string) string {
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	builder := strings.Builder{}
+
+	for _, k := range keys {
+		builder.WriteString(k)
+		builder.WriteByte('=')
+		builder.WriteString(tags[k])
+		builder.WriteByte(',')
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,147 @@
+package opentsdb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The downsample fill policies
+const (
+	FillNone     string = "none"
+	FillNaN      string = "nan"
+	FillNull     string = "null"
+	FillZero     string = "zero"
+	FillPrevious string = "previous"
+	FillLinear   string = "linear"
+	FillConstant string = "constant"
+)
+
+// fillConstantSeparator - separates the constant fill policy from its value, like constant:-1
+const fillConstantSeparator string = ":"
+
+// fillPolicy - a parsed fill policy
+type fillPolicy struct {
+	name  string
+	value float64
+}
+
+func parseFillPolicy(s string) (fillPolicy, error) {
+
+	if strings.HasPrefix(s, FillConstant+fillConstantSeparator) {
+
+		value, err := strconv.ParseFloat(s[len(FillConstant)+len(fillConstantSeparator):], 64)
+		if err != nil {
+			return fillPolicy{}, fmt.Errorf("invalid constant fill value %s", s)
+		}
+
+		return fillPolicy{name: FillConstant, value: value}, nil
+	}
+
+	switch s {
+	case FillNone, FillZero, FillPrevious, FillLinear:
+		return fillPolicy{name: s}, nil
+	case FillNaN, FillNull:
+		return fillPolicy{name: s, value: math.NaN()}, nil
+	case FillConstant:
+		return fillPolicy{}, errors.New("constant fill needs a value like constant:0")
+	}
+
+	return fillPolicy{}, errors.New("invalid fill value")
+}
+
+// String - returns the fill policy as it is written in a downsample
+func (fp fillPolicy) String() string {
+
+	if fp.name == FillConstant {
+		return FillConstant + fillConstantSeparator + formatValue(fp.value)
+	}
+
+	return fp.name
+}
+
+// FillBuckets - returns the full bucket timeline of a downsample like 1m-avg-zero between start and end, both
+// epochs in milliseconds, where each bucket without a downsampled point is filled according to the fill policy:
+// none skips the bucket, nan and null use NaN, zero uses 0, previous repeats the last value, linear interpolates
+// between the surrounding values and constant:<v> uses the value v. Buckets that previous and linear cannot
+// fill are skipped. Calendar intervals are aligned in the location.
+func FillBuckets(points DataPoints, downsample string, start, end int64, loc *time.Location) (DataPoints, error) {
+
+	info := strings.SplitN(downsample, "-", 3)
+
+	if len(info) < 2 {
+		return nil, errors.New("invalid downsample format")
+	}
+
+	interval, err := parseDownsampleInterval(info[0])
+	if err != nil {
+		return nil, err
+	}
+
+	policy := fillPolicy{name: FillNone}
+
+	if len(info) > 2 {
+		if policy, err = parseFillPolicy(info[2]); err != nil {
+			return nil, err
+		}
+	}
+
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	filled := DataPoints{}
+
+	bucket := interval.bucketStart(time.Unix(0, start*int64(time.Millisecond)).In(loc))
+
+	if policy.name == FillNone {
+		for _, p := range points {
+			if p.Timestamp >= toMillis(bucket) && p.Timestamp <= end {
+				filled = append(filled, p)
+			}
+		}
+		return filled, nil
+	}
+
+	i := 0
+
+	for ts := toMillis(bucket); ts <= end; ts = toMillis(bucket) {
+
+		for i < len(points) && points[i].Timestamp < ts {
+			i++
+		}
+
+		if i < len(points) && points[i].Timestamp == ts {
+			filled = append(filled, points[i])
+		} else if value, ok := policy.fill(points, i, ts); ok {
+			filled = append(filled, DataPoint{Timestamp: ts, Value: value})
+		}
+
+		bucket = interval.nextBucketStart(bucket)
+	}
+
+	return filled, nil
+}
+
+// fill - returns the value of a missing bucket, next is the index of the first point after the bucket
+func (fp fillPolicy) fill(points DataPoints, next int, ts int64) (float64, bool) {
+
+	switch fp.name {
+	case FillPrevious:
+		if next == 0 {
+			return 0, false
+		}
+		return points[next-1].Value, true
+	case FillLinear:
+		if next == 0 || next == len(points) {
+			return 0, false
+		}
+		prev, cur := points[next-1], points[next]
+		return prev.Value + (cur.Value-prev.Value)*float64(ts-prev.Timestamp)/float64(cur.Timestamp-prev.Timestamp), true
+	}
+
+	return fp.value, true
+}
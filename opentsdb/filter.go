@@ -1,34 +1,101 @@
 package opentsdb
 
-import (
-	"errors"
-	"fmt"
-)
+import "fmt"
 
-func parseFilter(exp string, tsdb *Expression) (string, error) {
+// FilterNode - the filter(condition,expression) function
+type FilterNode struct {
+	position
 
-	params := parseParams(string(exp[6:]))
+	Condition string
+	Child     Node
+}
+
+// Function - returns the expression function name of the node
+func (n *FilterNode) Function() string {
+	return "filter"
+}
+
+// Children - returns the nodes nested inside this node
+func (n *FilterNode) Children() []Node {
+	return []Node{n.Child}
+}
+
+// String - writes the node and its children as an expression
+func (n *FilterNode) String() string {
+	return writeFilter(n.Child.String(), n.Condition)
+}
+
+func (n *FilterNode) lower(tsdb *Expression) (string, error) {
 
-	if len(params) != 2 {
-		return stringsEmpty, fmt.Errorf("filter expects 2 parameters but found %d: %v", len(params), params)
+	relative, err := n.Child.lower(tsdb)
+	if err != nil {
+		return relative, err
 	}
 
-	tsdb.FilterValue = params[0]
+	if hasOperation(tsdb, "filterValue") {
+		return stringsEmpty, newDuplicateError(n, n.position, tsdb, "found more than one 'filterValue' function")
+	}
 
-	for _, oper := range tsdb.Order {
-		if oper == "filterValue" {
-			return stringsEmpty, errors.New("found more than one 'filterValue' function")
-		}
+	tsdb.FilterValue = n.Condition
+
+	tsdb.Order = append(tsdb.Order, "filterValue")
+
+	return relative, nil
+}
+
+func parseFilter(exp string, offset int) (Node, error) {
+
+	params, err := parseArgs(exp, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkParams("filter", params, 2); err != nil {
+		return nil, err
+	}
+
+	predicate, perr := parseValuePredicate(params[0].value, params[0].offset)
+	if perr != nil {
+		return nil, perr
 	}
 
-	tsdb.Order = append([]string{"filterValue"}, tsdb.Order...)
+	condition := (&ValueMatcher{root: predicate}).String()
+
+	child, err := parseExpression(params[1].value, params[1].offset)
+	if err != nil {
+		return nil, err
+	}
 
-	return params[1], nil
+	return &FilterNode{
+		Condition: condition,
+		Child:     child,
+	}, nil
 }
 
+// writeFilter - writes the filter function, with the condition in its canonical form when it is valid
 func writeFilter(exp, filterValue string) string {
-	if filterValue != stringsEmpty {
-		return fmt.Sprintf("filter(%s,%s)", filterValue, exp)
+
+	if filterValue == stringsEmpty {
+		return exp
+	}
+
+	if matcher, err := CompileFilterValue(filterValue); err == nil {
+		filterValue = matcher.String()
 	}
-	return exp
+
+	return fmt.Sprintf("filter(%s,%s)", filterValue, exp)
+}
+
+// filterValueSeries - keeps only the points matching the filter
+func filterValueSeries(points DataPoints, match func(float64) bool) DataPoints {
+
+	filtered := DataPoints{}
+
+	for _, p := range points {
+		if match(p.Value) {
+			filtered = append(filtered, p)
+		}
+	}
+
+	return filtered
 }
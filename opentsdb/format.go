@@ -0,0 +1,107 @@
+package opentsdb
+
+import "strings"
+
+// FormatAST - writes an abstract syntax tree in its canonical form, in a single line when indent is empty or
+// with one function per line otherwise, each nested function indented once more than its parent and the
+// operands of an arithmetic operator kept in its line, like:
+//
+//	groupBy({host=*})|
+//	merge(sum,
+//	  downsample(1m,avg,none,
+//	    query(os.cpu,null,1h)))
+//
+// Both forms are parsed back to the same tree.
+func FormatAST(node Node, indent string) string {
+
+	if indent == stringsEmpty {
+		return node.String()
+	}
+
+	b := strings.Builder{}
+
+	formatNode(&b, node, indent, 0)
+
+	return b.String()
+}
+
+// FormatExpression - writes the expression of a query in its canonical form like FormatAST
+func FormatExpression(exp Expression, relative, indent string) (string, error) {
+
+	compiled := CompileExpression([]Query{{Relative: relative, Queries: []Expression{exp}}})
+
+	node, err := ParseAST(compiled[0])
+	if err != nil {
+		return stringsEmpty, err
+	}
+
+	return FormatAST(node, indent), nil
+}
+
+// formatNode - writes the node arguments in the current line and each of its children in the following ones,
+// indented once for every parenthesis left open before them, so the arguments of a function are nested while
+// the expression after the | of groupBy is kept at the depth of the node. The operands of an arithmetic
+// operator are kept in the line of the operator, only breaking lines inside their function arguments.
+func formatNode(b *strings.Builder, node Node, indent string, depth int) {
+
+	exp := node.String()
+	children := node.Children()
+
+	// the children are searched from the end since only the node text can come before them
+	starts := make([]int, len(children))
+	end := len(exp)
+
+	for i := len(children) - 1; i >= 0; i-- {
+		if starts[i] = strings.LastIndex(exp[:end], children[i].String()); starts[i] == -1 {
+			b.WriteString(exp)
+			return
+		}
+		end = starts[i]
+	}
+
+	_, inline := node.(*BinaryNode)
+
+	open := 0
+	pos := 0
+
+	for i, child := range children {
+
+		text := exp[pos:starts[i]]
+		b.WriteString(text)
+
+		if inline {
+			formatNode(b, child, indent, depth)
+		} else {
+			open += openParens(text)
+			b.WriteString("\n" + strings.Repeat(indent, depth+open))
+			formatNode(b, child, indent, depth+open)
+		}
+
+		pos = starts[i] + len(child.String())
+	}
+
+	b.WriteString(exp[pos:])
+}
+
+// openParens - returns the number of parentheses opened and not closed in the text, skipping quoted values
+func openParens(s string) int {
+
+	open := 0
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			open++
+		case ')':
+			open--
+		case '"':
+			if opensQuote(s, i) {
+				if i = closeQuote(s, i); i == -1 {
+					return open
+				}
+			}
+		}
+	}
+
+	return open
+}
@@ -1,3 +1,5 @@
 module github.com/uol/mycenae-shared/opentsdb
 
 go 1.14
+
+require github.com/buger/jsonparser v1.0.0
@@ -1,185 +1,101 @@
 package opentsdb
 
 import (
-	"errors"
 	"fmt"
-	"sort"
 	"strings"
+	"unicode"
 )
 
-func parseGroup(exp string, tsdb *Expression) (string, error) {
+// GroupByNode - the groupBy({tags})|expression function
+type GroupByNode struct {
+	position
 
-	var queryExp string
+	Filters []Filter
+	Child   Node
+}
 
-	for i := 1; i < len(exp); i++ {
+// Function - returns the expression function name of the node
+func (n *GroupByNode) Function() string {
+	return "groupBy"
+}
 
-		if string(exp[i]) == "(" {
-			f := 1
-			for j := i + 1; j < len(exp); j++ {
+// Children - returns the nodes nested inside this node
+func (n *GroupByNode) Children() []Node {
+	return []Node{n.Child}
+}
 
-				if string(exp[j]) == "(" {
-					f++
-				}
+// String - writes the node and its children as an expression
+func (n *GroupByNode) String() string {
+	return writeGroup(n.Child.String(), n.Filters)
+}
 
-				if string(exp[j]) == ")" {
-					f--
-				}
+func (n *GroupByNode) lower(tsdb *Expression) (string, error) {
 
-				if f == 0 {
-					i = j + 1
-					if i == len(exp) {
-						return stringsEmpty, errors.New("groupBy cannot be used by itself")
-					}
+	relative, err := n.Child.lower(tsdb)
+	if err != nil {
+		return relative, err
+	}
 
-					if string(exp[i]) != "|" {
-						return stringsEmpty, errors.New("groupBy should be followed by a |")
-					}
+	if hasOperation(tsdb, "groupBy") {
+		return stringsEmpty, newDuplicateError(n, n.position, tsdb, "found more than one 'groupBy' function")
+	}
 
-					if i+1 == len(exp) {
-						return stringsEmpty, errors.New("groupBy should be followed by a | and a query expression")
-					}
+	tsdb.Filters = append(tsdb.Filters, n.Filters...)
 
-					queryExp = exp[i+1:]
-					exp = exp[:i]
-					break
-				}
-			}
-		}
-	}
+	tsdb.Order = append(tsdb.Order, "groupBy")
 
-	params := parseParams(string(exp[7:]))
+	return relative, nil
+}
 
-	if len(params) != 1 {
-		return stringsEmpty, fmt.Errorf("groupBy expects 1 parameter but found %d: %v", len(params), params)
-	}
+func parseGroup(exp string, offset int) (Node, error) {
 
-	tags, err := parseMap(params[0])
+	params, end, err := parseParams(exp, offset)
 	if err != nil {
-		return stringsEmpty, err
-	}
-
-	for k, vs := range tags {
-		for _, v := range vs {
-
-			var ft, cv string
-
-			if strings.HasPrefix(v, "regexp(") && strings.HasSuffix(v, ")") {
-				ft = "regexp"
-				cv = v[7 : len(v)-1]
-			} else if strings.HasPrefix(v, "wildcard(") && strings.HasSuffix(v, ")") {
-				ft = "wildcard"
-				cv = v[9 : len(v)-1]
-			} else if strings.HasPrefix(v, "or(") && strings.HasSuffix(v, ")") {
-				ft = "literal_or"
-				cv = v[3 : len(v)-1]
-			} else if strings.HasPrefix(v, "notor(") && strings.HasSuffix(v, ")") {
-				ft = "not_literal_or"
-				cv = v[6 : len(v)-1]
-			} else {
-				ft = "wildcard"
-				cv = v
-			}
-
-			filter := Filter{
-				Ftype:   ft,
-				Tagk:    k,
-				Filter:  cv,
-				GroupBy: true,
-			}
-			tsdb.Filters = append(tsdb.Filters, filter)
-		}
+		return nil, err
 	}
 
-	for _, oper := range tsdb.Order {
-		if oper == "groupBy" {
-			return stringsEmpty, errors.New("found more than one 'groupBy' function")
-		}
+	if err := checkParams("groupBy", params, 1); err != nil {
+		return nil, err
 	}
 
-	tsdb.Order = append([]string{"groupBy"}, tsdb.Order...)
+	rest := strings.TrimLeftFunc(exp[end:], unicode.IsSpace)
+	restOffset := offset + len(exp) - len(rest)
 
-	return queryExp, nil
-}
-
-func writeGroup(exp string, filters []Filter) string {
-
-	gExp := stringsEmpty
-
-	orderedTags := []string{}
-
-	joinFilters := map[string][]string{}
-
-	for _, filter := range filters {
-		if filter.GroupBy {
-			if _, ok := joinFilters[filter.Tagk]; !ok {
-				switch filter.Ftype {
-				case "wildcard":
-					joinFilters[filter.Tagk] = []string{
-						filter.Filter,
-					}
-				case "regexp":
-					joinFilters[filter.Tagk] = []string{
-						fmt.Sprintf("%s(%s)", filter.Ftype, filter.Filter),
-					}
-				case "literal_or":
-					joinFilters[filter.Tagk] = []string{
-						fmt.Sprintf("or(%s)", filter.Filter),
-					}
-				case "not_literal_or":
-					joinFilters[filter.Tagk] = []string{
-						fmt.Sprintf("notor(%s)", filter.Filter),
-					}
-				}
-				orderedTags = append(orderedTags, filter.Tagk)
-			} else {
-				switch filter.Ftype {
-				case "wildcard":
-					joinFilters[filter.Tagk] = append(
-						joinFilters[filter.Tagk],
-						filter.Filter,
-					)
-				case "regexp":
-					joinFilters[filter.Tagk] = append(
-						joinFilters[filter.Tagk],
-						fmt.Sprintf("%s(%s)", filter.Ftype, filter.Filter),
-					)
-				case "literal_or":
-					joinFilters[filter.Tagk] = append(
-						joinFilters[filter.Tagk],
-						fmt.Sprintf("or(%s)", filter.Ftype),
-					)
-					joinFilters[filter.Tagk] = []string{
-						fmt.Sprintf("or(%s)", filter.Filter),
-					}
-				case "not_literal_or":
-					joinFilters[filter.Tagk] = append(
-						joinFilters[filter.Tagk],
-						fmt.Sprintf("notor(%s)", filter.Ftype),
-					)
-				}
-			}
-		}
+	if rest == stringsEmpty {
+		return nil, newParseError(restOffset, stringsEmpty, []string{"|"}, "groupBy cannot be used by itself")
 	}
 
-	if len(orderedTags) > 0 {
+	if rest[0] != '|' {
+		return nil, newParseError(restOffset, rest[:1], []string{"|"}, "groupBy should be followed by a |")
+	}
 
-		gExp = "groupBy({"
+	if strings.TrimSpace(rest[1:]) == stringsEmpty {
+		return nil, newParseError(restOffset+1, stringsEmpty, expressionFunctions, "groupBy should be followed by a | and a query expression")
+	}
 
-		sort.Strings(orderedTags)
+	filters, err := parseTagFilters(params[0], true)
+	if err != nil {
+		return nil, err
+	}
 
-		for _, tk := range orderedTags {
+	child, err := parseExpression(rest[1:], restOffset+1)
+	if err != nil {
+		return nil, err
+	}
 
-			sort.Strings(joinFilters[tk])
+	return &GroupByNode{
+		Filters: filters,
+		Child:   child,
+	}, nil
+}
 
-			for _, fv := range joinFilters[tk] {
-				gExp = fmt.Sprintf("%s%s=%s,", gExp, tk, fv)
-			}
-		}
+func writeGroup(exp string, filters []Filter) string {
 
-		gExp = gExp[:len(gExp)-1]
+	tags := writeTagFilters(filters, true)
 
-		exp = fmt.Sprintf("%s})|%s", gExp, exp)
+	if tags == stringsEmpty {
+		return exp
 	}
 
-	return exp
+	return fmt.Sprintf("groupBy(%s)|%s", tags, exp)
 }
@@ -0,0 +1,188 @@
+package opentsdb
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// maxCachedRegexps - the number of compiled regular expressions kept by the cache
+const maxCachedRegexps int = 1024
+
+var (
+	regexpCache      = map[string]*regexp.Regexp{}
+	regexpCacheMutex sync.RWMutex
+)
+
+// TagMatcher - a compiled tag filter
+type TagMatcher struct {
+	Filter Filter
+	match  func(value string) bool
+}
+
+// TagMatchers - compiled tag filters which match when all of them match
+type TagMatchers []*TagMatcher
+
+// CompileFilter - compiles a tag filter of any type returned by GetFilters, including
+// the case insensitive iliteral_or, not_iliteral_or and iwildcard variants
+func CompileFilter(filter Filter) (*TagMatcher, error) {
+
+	m := &TagMatcher{
+		Filter: filter,
+	}
+
+	switch filter.Ftype {
+	case "literal_or":
+		m.match = literalOrMatcher(filter.Filter, false, false)
+	case "iliteral_or":
+		m.match = literalOrMatcher(filter.Filter, true, false)
+	case "not_literal_or":
+		m.match = literalOrMatcher(filter.Filter, false, true)
+	case "not_iliteral_or":
+		m.match = literalOrMatcher(filter.Filter, true, true)
+	case "wildcard":
+		m.match = wildcardMatcher(filter.Filter, false)
+	case "iwildcard":
+		m.match = wildcardMatcher(filter.Filter, true)
+	case "regexp":
+		re, err := compileRegexp(filter.Filter)
+		if err != nil {
+			return nil, err
+		}
+		m.match = re.MatchString
+	default:
+		return nil, fmt.Errorf("invalid filter type %s", filter.Ftype)
+	}
+
+	return m, nil
+}
+
+// CompileFilters - compiles all tag filters
+func CompileFilters(filters []Filter) (TagMatchers, error) {
+
+	matchers := make(TagMatchers, 0, len(filters))
+
+	for _, filter := range filters {
+
+		m, err := CompileFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+
+		matchers = append(matchers, m)
+	}
+
+	return matchers, nil
+}
+
+// MatchValue - checks if a value of the filter tag key matches the filter
+func (m *TagMatcher) MatchValue(value string) bool {
+	return m.match(value)
+}
+
+// Match - checks if the tag set has the filter tag key with a matching value
+func (m *TagMatcher) Match(tags map[string]string) bool {
+
+	value, ok := tags[m.Filter.Tagk]
+	if !ok {
+		return false
+	}
+
+	return m.match(value)
+}
+
+// Match - checks if the tag set matches all filters
+func (ms TagMatchers) Match(tags map[string]string) bool {
+
+	for _, m := range ms {
+		if !m.Match(tags) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func literalOrMatcher(filter string, ignoreCase, not bool) func(string) bool {
+
+	values := map[string]bool{}
+
+	for _, v := range strings.Split(filter, "|") {
+		if ignoreCase {
+			v = strings.ToLower(v)
+		}
+		values[v] = true
+	}
+
+	return func(value string) bool {
+		if ignoreCase {
+			value = strings.ToLower(value)
+		}
+		return values[value] != not
+	}
+}
+
+func wildcardMatcher(filter string, ignoreCase bool) func(string) bool {
+
+	if ignoreCase {
+		filter = strings.ToLower(filter)
+	}
+
+	parts := strings.Split(filter, "*")
+
+	return func(value string) bool {
+
+		if ignoreCase {
+			value = strings.ToLower(value)
+		}
+
+		if len(parts) == 1 {
+			return value == parts[0]
+		}
+
+		prefix, suffix := parts[0], parts[len(parts)-1]
+
+		if len(value) < len(prefix)+len(suffix) || !strings.HasPrefix(value, prefix) || !strings.HasSuffix(value, suffix) {
+			return false
+		}
+
+		value = value[len(prefix) : len(value)-len(suffix)]
+
+		for _, part := range parts[1 : len(parts)-1] {
+			i := strings.Index(value, part)
+			if i == -1 {
+				return false
+			}
+			value = value[i+len(part):]
+		}
+
+		return true
+	}
+}
+
+// compileRegexp - compiles a regular expression, reusing the ones already compiled
+func compileRegexp(expr string) (*regexp.Regexp, error) {
+
+	regexpCacheMutex.RLock()
+	re, ok := regexpCache[expr]
+	regexpCacheMutex.RUnlock()
+
+	if ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	regexpCacheMutex.Lock()
+	if len(regexpCache) >= maxCachedRegexps {
+		regexpCache = map[string]*regexp.Regexp{}
+	}
+	regexpCache[expr] = re
+	regexpCacheMutex.Unlock()
+
+	return re, nil
+}
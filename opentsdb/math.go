@@ -0,0 +1,608 @@
+package opentsdb
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// mathFunctions - the functions combining the results of expressions
+var mathFunctions = []string{
+	"sum",
+	"scale",
+	"abs",
+	"timeShift",
+}
+
+// mathExpected - the functions that can start an expression
+var mathExpected = append(append([]string{}, expressionFunctions...), mathFunctions...)
+
+// mathOperators - the arithmetic operators and their precedence
+var mathOperators = map[byte]int{
+	'+': 1,
+	'-': 1,
+	'*': 2,
+	'/': 2,
+}
+
+// errMathLower - returned when lowering a tree with expression math into a single query
+var errMathLower = errors.New("expression math cannot be lowered into a single query, evaluate it with EvaluateAST")
+
+// mathNode - a node combining the results of other nodes
+type mathNode interface {
+	Node
+
+	// precedence - the binding of the node when written next to an arithmetic operator
+	precedence() int
+
+	// evaluate - combines the results of the children
+	evaluate(e *mathEvaluator) (mathValue, error)
+}
+
+// NumberNode - a number used as an operand of an arithmetic operator
+type NumberNode struct {
+	Value float64
+}
+
+// Function - returns the expression function name of the node
+func (n *NumberNode) Function() string {
+	return "number"
+}
+
+// Children - returns the nodes nested inside this node
+func (n *NumberNode) Children() []Node {
+	return nil
+}
+
+// String - writes the node as an expression
+func (n *NumberNode) String() string {
+	return formatValue(n.Value)
+}
+
+func (n *NumberNode) lower(tsdb *Expression) (string, error) {
+	return stringsEmpty, errMathLower
+}
+
+func (n *NumberNode) precedence() int {
+	return 3
+}
+
+// BinaryNode - an arithmetic operation between two expressions or an expression and a number, like a/b*100
+type BinaryNode struct {
+	Operator string
+	Left     Node
+	Right    Node
+}
+
+// Function - returns the operator of the node
+func (n *BinaryNode) Function() string {
+	return n.Operator
+}
+
+// Children - returns the nodes nested inside this node
+func (n *BinaryNode) Children() []Node {
+	return []Node{n.Left, n.Right}
+}
+
+// String - writes the node and its children as an expression, with parentheses only when needed
+func (n *BinaryNode) String() string {
+
+	p := n.precedence()
+
+	left := n.Left.String()
+	if nodePrecedence(n.Left) < p {
+		left = fmt.Sprintf("(%s)", left)
+	}
+
+	right := n.Right.String()
+	if nodePrecedence(n.Right) <= p {
+		right = fmt.Sprintf("(%s)", right)
+	}
+
+	return left + n.Operator + right
+}
+
+func (n *BinaryNode) lower(tsdb *Expression) (string, error) {
+	return stringsEmpty, errMathLower
+}
+
+func (n *BinaryNode) precedence() int {
+	return mathOperators[n.Operator[0]]
+}
+
+// nodePrecedence - returns the binding of a node next to an arithmetic operator, functions bind the most
+func nodePrecedence(node Node) int {
+
+	if m, ok := node.(mathNode); ok {
+		return m.precedence()
+	}
+
+	return 3
+}
+
+// mathParser - a recursive descent parser of arithmetic between expressions
+type mathParser struct {
+	exp    string
+	pos    int
+	offset int
+}
+
+// parseMath - parses an expression which can combine query pipelines with arithmetic operators and
+// math functions, the offset is the position of the expression inside the whole one
+func parseMath(exp string, offset int) (Node, error) {
+
+	p := &mathParser{exp: exp, offset: offset}
+
+	node, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.skipSpaces(); p.pos < len(p.exp) {
+		return nil, newParseError(p.offset+p.pos, p.exp[p.pos:], []string{"+", "-", "*", "/"}, "unexpected %s after expression", p.exp[p.pos:])
+	}
+
+	return node, nil
+}
+
+func (p *mathParser) parseSum() (Node, error) {
+	return p.parseOperation(1)
+}
+
+// parseOperation - parses the operations with operators of the precedence or higher, left associative
+func (p *mathParser) parseOperation(precedence int) (Node, error) {
+
+	var left Node
+	var err error
+
+	if precedence == 2 {
+		left, err = p.parsePrimary()
+	} else {
+		left, err = p.parseOperation(precedence + 1)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+
+		p.skipSpaces()
+
+		if p.pos >= len(p.exp) || mathOperators[p.exp[p.pos]] != precedence {
+			return left, nil
+		}
+
+		operator := p.exp[p.pos : p.pos+1]
+		p.pos++
+
+		var right Node
+
+		if precedence == 2 {
+			right, err = p.parsePrimary()
+		} else {
+			right, err = p.parseOperation(precedence + 1)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		left = &BinaryNode{Operator: operator, Left: left, Right: right}
+	}
+}
+
+func (p *mathParser) parsePrimary() (Node, error) {
+
+	p.skipSpaces()
+
+	if p.pos >= len(p.exp) {
+		return nil, newParseError(p.offset+p.pos, stringsEmpty, mathExpected, "missing expression")
+	}
+
+	c := p.exp[p.pos]
+
+	if c == '(' {
+
+		start := p.pos
+		p.pos++
+
+		node, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.skipSpaces(); p.pos >= len(p.exp) || p.exp[p.pos] != ')' {
+			return nil, newParseError(p.offset+start, p.exp[start:], []string{")"}, "unclosed parenthesis")
+		}
+
+		p.pos++
+
+		return node, nil
+	}
+
+	if c == '-' && (p.pos+1 >= len(p.exp) || (p.exp[p.pos+1] != '.' && !unicode.IsDigit(rune(p.exp[p.pos+1])))) {
+
+		p.pos++
+
+		operand, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+
+		// the unary minus of an expression is its subtraction from zero, like 0-merge(sum,...)
+		return &BinaryNode{Operator: "-", Left: &NumberNode{Value: 0}, Right: operand}, nil
+	}
+
+	if c == '-' || c == '.' || unicode.IsDigit(rune(c)) {
+
+		end := scanNumber(p.exp, p.pos)
+
+		n, err := strconv.ParseFloat(p.exp[p.pos:end], 64)
+		if err != nil {
+			return nil, newParseError(p.offset+p.pos, p.exp[p.pos:end], []string{"<number>"}, "invalid number")
+		}
+
+		p.pos = end
+
+		return &NumberNode{Value: n}, nil
+	}
+
+	start := p.pos
+
+	name, err := p.parseCall()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range mathFunctions {
+		if f == name {
+			return p.parseFunction(name, start)
+		}
+	}
+
+	known := false
+
+	for _, f := range expressionFunctions {
+		known = known || f == name
+	}
+
+	if !known {
+		return nil, newParseError(p.offset+start, name, mathExpected, "unknown function %s", name)
+	}
+
+	for name == "groupBy" {
+
+		if p.skipSpaces(); p.pos >= len(p.exp) || p.exp[p.pos] != '|' {
+			break
+		}
+
+		p.pos++
+
+		if name, err = p.parseCall(); err != nil {
+			return nil, err
+		}
+	}
+
+	return parseExpression(p.exp[start:p.pos], p.offset+start)
+}
+
+// parseCall - skips a function call, returning the function name
+func (p *mathParser) parseCall() (string, error) {
+
+	p.skipSpaces()
+
+	start := p.pos
+
+	for p.pos < len(p.exp) && (unicode.IsLetter(rune(p.exp[p.pos])) || unicode.IsDigit(rune(p.exp[p.pos])) || p.exp[p.pos] == '_') {
+		p.pos++
+	}
+
+	name := p.exp[start:p.pos]
+
+	if name == stringsEmpty {
+		return stringsEmpty, newParseError(p.offset+start, p.exp[start:], mathExpected, "missing expression")
+	}
+
+	p.skipSpaces()
+
+	if p.pos >= len(p.exp) || p.exp[p.pos] != '(' {
+		return stringsEmpty, newParseError(p.offset+p.pos, p.exp[p.pos:], []string{"("}, "missing '(' after %s", name)
+	}
+
+	_, end, err := parseParams(p.exp[p.pos:], p.offset+p.pos)
+	if err != nil {
+		return stringsEmpty, err
+	}
+
+	p.pos += end
+
+	return name, nil
+}
+
+// parseFunction - parses a math function call starting at start
+func (p *mathParser) parseFunction(name string, start int) (Node, error) {
+
+	call := p.exp[start:p.pos]
+	i := strings.IndexByte(call, '(')
+
+	params, err := parseArgs(call[i:], p.offset+start+i)
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "sum":
+		return parseSum(params)
+	case "scale":
+		return parseScale(params)
+	case "abs":
+		return parseAbs(params)
+	}
+
+	return parseTimeShift(params)
+}
+
+func (p *mathParser) skipSpaces() {
+	for p.pos < len(p.exp) && unicode.IsSpace(rune(p.exp[p.pos])) {
+		p.pos++
+	}
+}
+
+// mathValue - the value of a node, either the results of queries or a number
+type mathValue struct {
+	results  []QueryResult
+	scalar   float64
+	isScalar bool
+}
+
+// mathEvaluator - evaluates a tree over the series, the query pipelines are evaluated between start and end
+type mathEvaluator struct {
+	series     []Series
+	start, end int64
+}
+
+// ValidateAST - validates the query pipelines of a tree, which must have at least one, as queries
+func ValidateAST(node Node) error {
+
+	if node == nil {
+		return errors.New("empty expression tree")
+	}
+
+	hasQuery := false
+
+	var err error
+
+	Walk(node, func(n Node) bool {
+
+		if err != nil {
+			return false
+		}
+
+		if _, ok := n.(mathNode); ok {
+			return true
+		}
+
+		hasQuery = true
+
+		_, err = lowerPipeline(n)
+
+		return false
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if !hasQuery {
+		return errors.New("expression must have at least one query")
+	}
+
+	return nil
+}
+
+// EvaluateAST - evaluates a tree over the series like EvaluateRange, combining the results of its query pipelines
+// with arithmetic and math functions. The series of the operands of an arithmetic operator are paired by their tags,
+// or each one with the only series of the other operand, and only the timestamps found in both are kept.
+func EvaluateAST(node Node, series []Series, start, end int64, msResolution bool) ([]QueryResult, error) {
+
+	if err := ValidateAST(node); err != nil {
+		return nil, err
+	}
+
+	e := &mathEvaluator{series: series, start: start, end: end}
+
+	value, err := e.eval(node)
+	if err != nil {
+		return nil, err
+	}
+
+	if value.isScalar {
+		return nil, errors.New("expression must have at least one query")
+	}
+
+	if !msResolution {
+		for _, result := range value.results {
+			for i := range result.DPS {
+				result.DPS[i].Timestamp /= 1000
+			}
+		}
+	}
+
+	sortResults(value.results)
+
+	return value.results, nil
+}
+
+func (e *mathEvaluator) eval(node Node) (mathValue, error) {
+
+	if m, ok := node.(mathNode); ok {
+		return m.evaluate(e)
+	}
+
+	exp, err := lowerPipeline(node)
+	if err != nil {
+		return mathValue{}, err
+	}
+
+	results, err := EvaluateRange(exp, e.series, e.start, e.end, true)
+	if err != nil {
+		return mathValue{}, err
+	}
+
+	return mathValue{results: results}, nil
+}
+
+// lowerPipeline - lowers a query pipeline into a validated TSDB query struct
+func lowerPipeline(node Node) (Expression, error) {
+
+	exp := Expression{}
+
+	relative, err := lowerExpression(node, &exp)
+	if err != nil {
+		return exp, err
+	}
+
+	query := Query{Relative: relative, Queries: []Expression{exp}}
+
+	if err := query.Validate(); err != nil {
+		return exp, err
+	}
+
+	return query.Queries[0], nil
+}
+
+// mapResults - returns a copy of the results with each value replaced by f, named after the node
+func mapResults(results []QueryResult, node Node, f func(float64) float64) []QueryResult {
+
+	mapped := make([]QueryResult, len(results))
+
+	for i, result := range results {
+
+		mapped[i] = result
+		mapped[i].Metric = node.String()
+		mapped[i].DPS = make(DataPoints, len(result.DPS))
+
+		for j, dp := range result.DPS {
+			mapped[i].DPS[j] = DataPoint{Timestamp: dp.Timestamp, Value: f(dp.Value)}
+		}
+	}
+
+	return mapped
+}
+
+func (n *NumberNode) evaluate(e *mathEvaluator) (mathValue, error) {
+	return mathValue{scalar: n.Value, isScalar: true}, nil
+}
+
+func (n *BinaryNode) evaluate(e *mathEvaluator) (mathValue, error) {
+
+	left, err := e.eval(n.Left)
+	if err != nil {
+		return mathValue{}, err
+	}
+
+	right, err := e.eval(n.Right)
+	if err != nil {
+		return mathValue{}, err
+	}
+
+	var op func(a, b float64) float64
+
+	switch n.Operator {
+	case "+":
+		op = func(a, b float64) float64 { return a + b }
+	case "-":
+		op = func(a, b float64) float64 { return a - b }
+	case "*":
+		op = func(a, b float64) float64 { return a * b }
+	default:
+		op = func(a, b float64) float64 { return a / b }
+	}
+
+	switch {
+	case left.isScalar && right.isScalar:
+		return mathValue{scalar: op(left.scalar, right.scalar), isScalar: true}, nil
+	case left.isScalar:
+		return mathValue{results: mapResults(right.results, n, func(v float64) float64 { return op(left.scalar, v) })}, nil
+	case right.isScalar:
+		return mathValue{results: mapResults(left.results, n, func(v float64) float64 { return op(v, right.scalar) })}, nil
+	}
+
+	results := []QueryResult{}
+
+	pair := func(l, r QueryResult, tags map[string]string) {
+
+		result := QueryResult{
+			Metric:        n.String(),
+			Tags:          tags,
+			AggregateTags: unionTags(l.AggregateTags, r.AggregateTags),
+			DPS:           DataPoints{},
+		}
+
+		for i, j := 0, 0; i < len(l.DPS) && j < len(r.DPS); {
+			switch {
+			case l.DPS[i].Timestamp < r.DPS[j].Timestamp:
+				i++
+			case l.DPS[i].Timestamp > r.DPS[j].Timestamp:
+				j++
+			default:
+				result.DPS = append(result.DPS, DataPoint{Timestamp: l.DPS[i].Timestamp, Value: op(l.DPS[i].Value, r.DPS[j].Value)})
+				i++
+				j++
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	switch {
+	case len(left.results) == 1 && len(right.results) == 1:
+		tags, aggregateTags := mergeTags([]Series{{Tags: left.results[0].Tags}, {Tags: right.results[0].Tags}})
+		pair(left.results[0], right.results[0], tags)
+		results[0].AggregateTags = unionTags(results[0].AggregateTags, aggregateTags)
+	case len(right.results) == 1:
+		for _, l := range left.results {
+			pair(l, right.results[0], l.Tags)
+		}
+	case len(left.results) == 1:
+		for _, r := range right.results {
+			pair(left.results[0], r, r.Tags)
+		}
+	default:
+		rights := map[string]QueryResult{}
+		for _, r := range right.results {
+			rights[tagsKey(r.Tags)] = r
+		}
+		for _, l := range left.results {
+			if r, ok := rights[tagsKey(l.Tags)]; ok {
+				pair(l, r, l.Tags)
+			}
+		}
+	}
+
+	return mathValue{results: results}, nil
+}
+
+// unionTags - returns the sorted tag keys from both lists without duplicates
+func unionTags(a, b []string) []string {
+
+	union := []string{}
+	seen := map[string]bool{}
+
+	for _, tags := range [][]string{a, b} {
+		for _, tag := range tags {
+			if !seen[tag] {
+				seen[tag] = true
+				union = append(union, tag)
+			}
+		}
+	}
+
+	sort.Strings(union)
+
+	return union
+}
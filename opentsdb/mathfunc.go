@@ -0,0 +1,306 @@
+package opentsdb
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// SumNode - the sum(expression,...) function, adding all series from its expressions into one
+type SumNode struct {
+	Expressions []Node
+}
+
+// Function - returns the expression function name of the node
+func (n *SumNode) Function() string {
+	return "sum"
+}
+
+// Children - returns the nodes nested inside this node
+func (n *SumNode) Children() []Node {
+	return n.Expressions
+}
+
+// String - writes the node and its children as an expression
+func (n *SumNode) String() string {
+
+	exps := make([]string, len(n.Expressions))
+
+	for i, exp := range n.Expressions {
+		exps[i] = exp.String()
+	}
+
+	return fmt.Sprintf("sum(%s)", strings.Join(exps, ","))
+}
+
+func (n *SumNode) lower(tsdb *Expression) (string, error) {
+	return stringsEmpty, errMathLower
+}
+
+func (n *SumNode) precedence() int {
+	return 3
+}
+
+func parseSum(params []param) (Node, error) {
+
+	node := &SumNode{}
+
+	for _, p := range params {
+
+		exp, err := parseMath(p.value, p.offset)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := exp.(*NumberNode); ok {
+			return nil, newParseError(p.offset, p.value, mathExpected, "sum expects expressions but found the number %s", p.value)
+		}
+
+		node.Expressions = append(node.Expressions, exp)
+	}
+
+	return node, nil
+}
+
+// ScaleNode - the scale(expression,factor) function, multiplying every value by the factor
+type ScaleNode struct {
+	Factor float64
+	Child  Node
+}
+
+// Function - returns the expression function name of the node
+func (n *ScaleNode) Function() string {
+	return "scale"
+}
+
+// Children - returns the nodes nested inside this node
+func (n *ScaleNode) Children() []Node {
+	return []Node{n.Child}
+}
+
+// String - writes the node and its children as an expression
+func (n *ScaleNode) String() string {
+	return fmt.Sprintf("scale(%s,%s)", n.Child.String(), formatValue(n.Factor))
+}
+
+func (n *ScaleNode) lower(tsdb *Expression) (string, error) {
+	return stringsEmpty, errMathLower
+}
+
+func (n *ScaleNode) precedence() int {
+	return 3
+}
+
+func parseScale(params []param) (Node, error) {
+
+	if err := checkParams("scale", params, 2); err != nil {
+		return nil, err
+	}
+
+	child, err := parseMath(params[0].value, params[0].offset)
+	if err != nil {
+		return nil, err
+	}
+
+	factor, err := strconv.ParseFloat(params[1].atom(), 64)
+	if err != nil {
+		return nil, newParseError(params[1].offset, params[1].value, []string{"<number>"}, "invalid scale factor")
+	}
+
+	return &ScaleNode{Factor: factor, Child: child}, nil
+}
+
+// AbsNode - the abs(expression) function, returning the absolute values
+type AbsNode struct {
+	Child Node
+}
+
+// Function - returns the expression function name of the node
+func (n *AbsNode) Function() string {
+	return "abs"
+}
+
+// Children - returns the nodes nested inside this node
+func (n *AbsNode) Children() []Node {
+	return []Node{n.Child}
+}
+
+// String - writes the node and its children as an expression
+func (n *AbsNode) String() string {
+	return fmt.Sprintf("abs(%s)", n.Child.String())
+}
+
+func (n *AbsNode) lower(tsdb *Expression) (string, error) {
+	return stringsEmpty, errMathLower
+}
+
+func (n *AbsNode) precedence() int {
+	return 3
+}
+
+func parseAbs(params []param) (Node, error) {
+
+	if err := checkParams("abs", params, 1); err != nil {
+		return nil, err
+	}
+
+	child, err := parseMath(params[0].value, params[0].offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AbsNode{Child: child}, nil
+}
+
+// TimeShiftNode - the timeShift(expression,duration) function, moving the points forward by the duration,
+// so timeShift(q,1d) evaluated for today shows the values of yesterday
+type TimeShiftNode struct {
+	Shift string
+	Child Node
+}
+
+// Function - returns the expression function name of the node
+func (n *TimeShiftNode) Function() string {
+	return "timeShift"
+}
+
+// Children - returns the nodes nested inside this node
+func (n *TimeShiftNode) Children() []Node {
+	return []Node{n.Child}
+}
+
+// String - writes the node and its children as an expression
+func (n *TimeShiftNode) String() string {
+	return fmt.Sprintf("timeShift(%s,%s)", n.Child.String(), n.Shift)
+}
+
+func (n *TimeShiftNode) lower(tsdb *Expression) (string, error) {
+	return stringsEmpty, errMathLower
+}
+
+func (n *TimeShiftNode) precedence() int {
+	return 3
+}
+
+// millis - returns the shift in milliseconds, days, weeks, months and years have fixed lengths like in downsamples
+func (n *TimeShiftNode) millis() int64 {
+
+	di, err := parseDownsampleInterval(n.Shift)
+	if err != nil {
+		return 0
+	}
+
+	return di.millis()
+}
+
+func parseTimeShift(params []param) (Node, error) {
+
+	if err := checkParams("timeShift", params, 2); err != nil {
+		return nil, err
+	}
+
+	child, err := parseMath(params[0].value, params[0].offset)
+	if err != nil {
+		return nil, err
+	}
+
+	shift := params[1].atom()
+
+	if strings.HasSuffix(shift, calendarSuffix) {
+		return nil, newParseError(params[1].offset, params[1].value, []string{"<duration>"}, "invalid time shift %s", shift)
+	}
+
+	if _, err := parseDownsampleInterval(shift); err != nil {
+		return nil, newParseError(params[1].offset, params[1].value, []string{"<duration>"}, "invalid time shift: %s", err)
+	}
+
+	return &TimeShiftNode{Shift: shift, Child: child}, nil
+}
+
+func (n *SumNode) evaluate(e *mathEvaluator) (mathValue, error) {
+
+	series := []Series{}
+	aggregateTags := []string{}
+
+	for _, exp := range n.Expressions {
+
+		value, err := e.eval(exp)
+		if err != nil {
+			return mathValue{}, err
+		}
+
+		if value.isScalar {
+			return mathValue{}, fmt.Errorf("sum expects expressions but found the number %s", exp.String())
+		}
+
+		for _, result := range value.results {
+			series = append(series, Series{Metric: n.String(), Tags: result.Tags, Points: result.DPS})
+			aggregateTags = unionTags(aggregateTags, result.AggregateTags)
+		}
+	}
+
+	if len(series) == 0 {
+		return mathValue{results: []QueryResult{}}, nil
+	}
+
+	merged, err := mergeSeries(series, "zimsum", nil)
+	if err != nil {
+		return mathValue{}, err
+	}
+
+	return mathValue{results: []QueryResult{{
+		Metric:        n.String(),
+		Tags:          merged[0].Tags,
+		AggregateTags: unionTags(aggregateTags, merged[0].AggregateTags),
+		DPS:           merged[0].Points,
+	}}}, nil
+}
+
+func (n *ScaleNode) evaluate(e *mathEvaluator) (mathValue, error) {
+
+	value, err := e.eval(n.Child)
+	if err != nil || value.isScalar {
+		value.scalar *= n.Factor
+		return value, err
+	}
+
+	return mathValue{results: mapResults(value.results, n, func(v float64) float64 { return v * n.Factor })}, nil
+}
+
+func (n *AbsNode) evaluate(e *mathEvaluator) (mathValue, error) {
+
+	value, err := e.eval(n.Child)
+	if err != nil || value.isScalar {
+		value.scalar = math.Abs(value.scalar)
+		return value, err
+	}
+
+	return mathValue{results: mapResults(value.results, n, math.Abs)}, nil
+}
+
+func (n *TimeShiftNode) evaluate(e *mathEvaluator) (mathValue, error) {
+
+	shift := n.millis()
+
+	shifted := &mathEvaluator{series: e.series, start: e.start - shift, end: e.end}
+
+	if e.end != 0 {
+		shifted.end = e.end - shift
+	}
+
+	value, err := shifted.eval(n.Child)
+	if err != nil || value.isScalar {
+		return value, err
+	}
+
+	results := mapResults(value.results, n, func(v float64) float64 { return v })
+
+	for _, result := range results {
+		for i := range result.DPS {
+			result.DPS[i].Timestamp += shift
+		}
+	}
+
+	return mathValue{results: results}, nil
+}
@@ -1,31 +1,324 @@
 package opentsdb
 
 import (
-	"errors"
 	"fmt"
+	"math"
+	"sort"
 )
 
-func parseMerge(exp string, tsdb *Expression) (string, error) {
+// MergeNode - the merge(aggregator,expression) function
+type MergeNode struct {
+	position
 
-	params := parseParams(string(exp[5:]))
+	Aggregator string
+	Child      Node
+}
+
+// Function - returns the expression function name of the node
+func (n *MergeNode) Function() string {
+	return "merge"
+}
+
+// Children - returns the nodes nested inside this node
+func (n *MergeNode) Children() []Node {
+	return []Node{n.Child}
+}
+
+// String - writes the node and its children as an expression
+func (n *MergeNode) String() string {
+	return writeMerge(n.Child.String(), n.Aggregator)
+}
 
-	if len(params) != 2 {
-		return stringsEmpty, fmt.Errorf("merge expects 2 parameters but found %d: %v", len(params), params)
+func (n *MergeNode) lower(tsdb *Expression) (string, error) {
+
+	relative, err := n.Child.lower(tsdb)
+	if err != nil {
+		return relative, err
+	}
+
+	if hasOperation(tsdb, "aggregation") {
+		return stringsEmpty, newDuplicateError(n, n.position, tsdb, "found more than one 'aggregation' function")
 	}
 
-	tsdb.Aggregator = params[0]
+	tsdb.Aggregator = n.Aggregator
 
-	for _, oper := range tsdb.Order {
-		if oper == "aggregation" {
-			return stringsEmpty, errors.New("found more than one 'aggregation' function")
-		}
+	tsdb.Order = append(tsdb.Order, "aggregation")
+
+	return relative, nil
+}
+
+func parseMerge(exp string, offset int) (Node, error) {
+
+	params, err := parseArgs(exp, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkParams("merge", params, 2); err != nil {
+		return nil, err
 	}
 
-	tsdb.Order = append([]string{"aggregation"}, tsdb.Order...)
+	if err := checkParamValue(params[0], GetAggregators(), "aggregator"); err != nil {
+		return nil, err
+	}
 
-	return params[1], nil
+	child, err := parseExpression(params[1].value, params[1].offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MergeNode{
+		Aggregator: params[0].atom(),
+		Child:      child,
+	}, nil
 }
 
 func writeMerge(exp, operator string) string {
 	return fmt.Sprintf("merge(%s,%s)", operator, exp)
 }
+
+// builtinAggregators - the aggregators available as merge functions and downsamplers
+var builtinAggregators = []Aggregator{
+	{Name: "avg", Description: "Averages the data points", Reduce: reduceAvg, Interpolate: true},
+	{Name: "count", Description: "The number of raw data points", Reduce: reduceCount},
+	{Name: "min", Description: "Selects the smallest data point", Reduce: reduceMin, Interpolate: true},
+	{Name: "max", Description: "Selects the largest data point", Reduce: reduceMax, Interpolate: true},
+	{Name: "sum", Description: "Adds the data points together", Reduce: reduceSum, Interpolate: true},
+	{Name: "zimsum", Description: "Adds the data points together, missing values are treated as zero", Reduce: reduceSum},
+	{Name: "mimmin", Description: "Selects the smallest data point, missing values are ignored", Reduce: reduceMin},
+	{Name: "mimmax", Description: "Selects the largest data point, missing values are ignored", Reduce: reduceMax},
+	{Name: "dev", Description: "Calculates the standard deviation", Reduce: reduceDev, Interpolate: true},
+	{Name: "median", Description: "Selects the median data point", Reduce: reducePercentile(50), Interpolate: true},
+	{Name: "first", Description: "Selects the first data point", Reduce: reduceFirst, Interpolate: true},
+	{Name: "last", Description: "Selects the last data point", Reduce: reduceLast, Interpolate: true},
+	{Name: "p50", Description: "Calculates the 50th percentile", Reduce: reducePercentile(50), Interpolate: true},
+	{Name: "p75", Description: "Calculates the 75th percentile", Reduce: reducePercentile(75), Interpolate: true},
+	{Name: "p90", Description: "Calculates the 90th percentile", Reduce: reducePercentile(90), Interpolate: true},
+	{Name: "p95", Description: "Calculates the 95th percentile", Reduce: reducePercentile(95), Interpolate: true},
+	{Name: "p99", Description: "Calculates the 99th percentile", Reduce: reducePercentile(99), Interpolate: true},
+	{Name: "p999", Description: "Calculates the 99.9th percentile", Reduce: reducePercentile(99.9), Interpolate: true},
+}
+
+func reduceAvg(values []float64) float64 {
+	return reduceSum(values) / float64(len(values))
+}
+
+func reduceCount(values []float64) float64 {
+	return float64(len(values))
+}
+
+func reduceMin(values []float64) float64 {
+	min := values[0]
+	for _, v := range values[1:] {
+		min = math.Min(min, v)
+	}
+	return min
+}
+
+func reduceMax(values []float64) float64 {
+	max := values[0]
+	for _, v := range values[1:] {
+		max = math.Max(max, v)
+	}
+	return max
+}
+
+func reduceSum(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum
+}
+
+// reduceDev - the population standard deviation
+func reduceDev(values []float64) float64 {
+	avg := reduceAvg(values)
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - avg) * (v - avg)
+	}
+	return math.Sqrt(variance / float64(len(values)))
+}
+
+func reduceFirst(values []float64) float64 {
+	return values[0]
+}
+
+func reduceLast(values []float64) float64 {
+	return values[len(values)-1]
+}
+
+// reducePercentile - the percentile estimated as OpenTSDB does, interpolating
+// between the closest ranks at the position p * (n + 1) / 100
+func reducePercentile(p float64) func([]float64) float64 {
+	return func(values []float64) float64 {
+
+		sorted := make([]float64, len(values))
+		copy(sorted, values)
+		sort.Float64s(sorted)
+
+		pos := p * float64(len(sorted)+1) / 100
+
+		if pos < 1 {
+			return sorted[0]
+		}
+
+		if pos >= float64(len(sorted)) {
+			return sorted[len(sorted)-1]
+		}
+
+		lower := sorted[int(pos)-1]
+		upper := sorted[int(pos)]
+
+		return lower + (pos-math.Floor(pos))*(upper-lower)
+	}
+}
+
+// mergeSeries - aggregates the series sharing the same values for the group by tags
+func mergeSeries(series []Series, name string, groupBy []string) ([]Series, error) {
+
+	aggr, ok := GetAggregator(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown aggregation value %s", name)
+	}
+
+	groups := map[string][]Series{}
+	keys := []string{}
+
+	for _, s := range series {
+
+		groupTags := map[string]string{}
+
+		complete := true
+
+		for _, tagk := range groupBy {
+			v, ok := s.Tags[tagk]
+			if !ok {
+				complete = false
+				break
+			}
+			groupTags[tagk] = v
+		}
+
+		if !complete {
+			continue
+		}
+
+		key := tagsKey(groupTags)
+
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+
+		groups[key] = append(groups[key], s)
+	}
+
+	sort.Strings(keys)
+
+	merged := make([]Series, 0, len(keys))
+
+	for _, key := range keys {
+
+		group := groups[key]
+
+		timestamps := []int64{}
+		for _, s := range group {
+			for _, p := range s.Points {
+				timestamps = append(timestamps, p.Timestamp)
+			}
+		}
+
+		sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+		points := DataPoints{}
+		values := make([]float64, 0, len(group))
+
+		for i, ts := range timestamps {
+
+			if i > 0 && timestamps[i-1] == ts {
+				continue
+			}
+
+			values = values[:0]
+			found := false
+
+			for _, s := range group {
+				if v, ok := valueAt(s.Points, ts, aggr.Interpolate); ok {
+					found = true
+					if !math.IsNaN(v) {
+						values = append(values, v)
+					}
+				}
+			}
+
+			if len(values) > 0 {
+				points = append(points, DataPoint{Timestamp: ts, Value: aggr.Reduce(values)})
+			} else if found {
+				points = append(points, DataPoint{Timestamp: ts, Value: math.NaN()})
+			}
+		}
+
+		tags, aggregateTags := mergeTags(group)
+
+		merged = append(merged, Series{
+			Metric:        group[0].Metric,
+			Tags:          tags,
+			AggregateTags: aggregateTags,
+			Points:        points,
+		})
+	}
+
+	return merged, nil
+}
+
+// valueAt - returns the series value at the timestamp, interpolated if requested
+func valueAt(points DataPoints, ts int64, interpolate bool) (float64, bool) {
+
+	i := sort.Search(len(points), func(i int) bool { return points[i].Timestamp >= ts })
+
+	if i < len(points) && points[i].Timestamp == ts {
+		return points[i].Value, true
+	}
+
+	if !interpolate || i == 0 || i == len(points) {
+		return 0, false
+	}
+
+	prev, next := points[i-1], points[i]
+
+	return prev.Value + (next.Value-prev.Value)*float64(ts-prev.Timestamp)/float64(next.Timestamp-prev.Timestamp), true
+}
+
+// mergeTags - returns the tags shared by all series and the keys of the ones that differ
+func mergeTags(series []Series) (map[string]string, []string) {
+
+	tags := map[string]string{}
+	aggregated := map[string]bool{}
+
+	for i, s := range series {
+		for k, v := range s.Tags {
+			if i == 0 {
+				tags[k] = v
+			} else if tv, ok := tags[k]; !ok || tv != v {
+				aggregated[k] = true
+			}
+		}
+		for k := range tags {
+			if _, ok := s.Tags[k]; !ok {
+				aggregated[k] = true
+			}
+		}
+	}
+
+	aggregateTags := []string{}
+
+	for k := range aggregated {
+		delete(tags, k)
+		aggregateTags = append(aggregateTags, k)
+	}
+
+	sort.Strings(aggregateTags)
+
+	return tags, aggregateTags
+}
@@ -1,12 +1,18 @@
 package opentsdb
 
 import (
-	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
+	"unicode"
 )
 
+// CheckDuration - validates a duration string accepted by GetRelativeStart, like 500ms, 1h or 2w
+func CheckDuration(s string) error {
+	return validateDuration(s)
+}
+
 // GetRelativeStart - returns a start time based on an end time and a duration string
 func GetRelativeStart(end time.Time, s string) (time.Time, error) {
 
@@ -36,121 +42,387 @@ func GetRelativeStart(end time.Time, s string) (time.Time, error) {
 	return time.Time{}, fmt.Errorf("unknown time unit: %s", s[len(s)-1:])
 }
 
-func parseParams(exp string) []string {
+// GetBucketStart - returns the start of the downsample bucket containing the time, calendar intervals
+// like 1dc are aligned to the calendar in the time location
+func GetBucketStart(t time.Time, interval string) (time.Time, error) {
 
-	var param []byte
+	di, err := parseDownsampleInterval(interval)
+	if err != nil {
+		return time.Time{}, err
+	}
 
-	params := []string{}
+	return di.bucketStart(t), nil
+}
 
-	for i := 1; i < len(exp); i++ {
+// GetNextBucketStart - returns the start of the downsample bucket following the one starting at the time
+func GetNextBucketStart(start time.Time, interval string) (time.Time, error) {
 
-		if string(exp[i]) == "(" {
-			param = append(param, exp[i])
-			f := 1
-			for j := i + 1; j < len(exp); j++ {
+	di, err := parseDownsampleInterval(interval)
+	if err != nil {
+		return time.Time{}, err
+	}
 
-				if string(exp[j]) == "(" {
-					f++
-				}
+	return di.nextBucketStart(start), nil
+}
 
-				if string(exp[j]) == ")" {
-					f--
-				}
+// param - a function parameter and its position in the expression
+type param struct {
+	value  string
+	offset int
+}
 
-				param = append(param, exp[j])
+func newParam(value string, offset int) param {
+	trimmed := strings.TrimLeftFunc(value, unicode.IsSpace)
+	return param{
+		value:  strings.TrimRightFunc(trimmed, unicode.IsSpace),
+		offset: offset + len(value) - len(trimmed),
+	}
+}
 
-				if f == 0 {
-					i = j + 1
-					if i == len(exp) {
-						return params
-					}
-					break
-				}
-			}
-		}
+func (p param) String() string {
+	return p.value
+}
+
+// atom - returns the parameter value without white spaces, except the ones inside quoted values
+func (p param) atom() string {
 
-		if string(exp[i]) == "{" {
-			param = append(param, exp[i])
-			for j := i + 1; j < len(exp); j++ {
+	if strings.IndexByte(p.value, '"') == -1 {
+		return removeSpaces(p.value)
+	}
 
-				param = append(param, exp[j])
+	b := strings.Builder{}
 
-				if string(exp[j]) == "}" {
-					i = j + 1
-					break
-				}
+	for i := 0; i < len(p.value); i++ {
+
+		if p.value[i] == '"' && opensQuote(p.value, i) {
+			end := closeQuote(p.value, i)
+			if end == -1 {
+				end = len(p.value) - 1
 			}
+			b.WriteString(p.value[i : end+1])
+			i = end
+			continue
 		}
 
-		if string(exp[i]) == "," {
-			params = append(params, string(param))
-			param = []byte{}
-			continue
+		if !unicode.IsSpace(rune(p.value[i])) {
+			b.WriteByte(p.value[i])
+		}
+	}
+
+	return b.String()
+}
+
+func removeSpaces(s string) string {
+	return strings.Join(strings.Fields(s), stringsEmpty)
+}
+
+// opensQuote - checks if the quote at the index starts a quoted value, which only happens at the beginning
+// of a parameter, map key or map value, other quotes are kept as they are like the one in regexp(a"b)
+func opensQuote(s string, i int) bool {
+
+	j := i - 1
+
+	for j >= 0 && unicode.IsSpace(rune(s[j])) {
+		j--
+	}
+
+	return j < 0 || strings.IndexByte("(,{=", s[j]) != -1
+}
+
+// closeQuote - returns the index of the quote closing the one at the start index, skipping the
+// ones escaped by a backslash, or -1 when the quoted value is not closed
+func closeQuote(s string, start int) int {
+
+	for i := start + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
 		}
+	}
 
-		if string(exp[i]) == ")" {
-			if i+1 == len(exp) {
-				params = append(params, string(param))
-				break
+	return -1
+}
+
+// indexUnquoted - returns the index of the first c outside quoted values, or -1 when not found
+func indexUnquoted(s string, c byte) int {
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == c:
+			return i
+		case s[i] == '"' && opensQuote(s, i):
+			if i = closeQuote(s, i); i == -1 {
+				return -1
 			}
-			return params
 		}
+	}
+
+	return -1
+}
+
+// unquote - returns the value of a quoted string like "a,b\"c" with its backslash escapes resolved,
+// values not starting with a quote are returned as they are
+func unquote(p param) (string, error) {
 
-		param = append(param, exp[i])
+	v := p.atom()
+
+	if !strings.HasPrefix(v, `"`) {
+		return v, nil
+	}
+
+	unquoted, err := strconv.Unquote(v)
+	if err != nil {
+		return stringsEmpty, newParseError(p.offset, p.value, nil, "invalid quoted value")
 	}
 
-	return params
+	return unquoted, nil
 }
 
-func parseMap(exp string) (map[string][]string, error) {
+// quote - returns the value quoted when it has characters with a meaning in expressions
+func quote(value string) string {
 
-	if len(exp) == 0 {
-		return nil, errors.New(`empty map`)
+	if value == stringsEmpty || strings.ContainsAny(value, ",=(){}\"\\ \t\r\n") {
+		return strconv.Quote(value)
 	}
 
-	if string(exp[0]) != "{" {
-		return nil, errors.New(`missing '{' at the beginning of map`)
+	return value
+}
+
+// parseParams - parses the parameters of a function, the expression must begin with '(' and
+// the returned index points right after the closing ')'
+func parseParams(exp string, offset int) ([]param, int, error) {
+
+	if len(exp) == 0 || exp[0] != '(' {
+		return nil, 0, newParseError(offset, exp, []string{"("}, "missing '(' at the beginning of parameters")
 	}
 
-	var key, value []byte
+	params := []param{}
 
-	m := map[string][]string{}
+	depth := 0
+	start := 1
 
 	for i := 1; i < len(exp); i++ {
 
-		if string(exp[i]) == "=" {
-
-			if len(key) == 0 {
-				return nil, errors.New(`map key cannot be empty`)
+		switch exp[i] {
+		case '"':
+			if !opensQuote(exp, i) {
+				continue
 			}
-
-			if _, ok := m[string(key)]; !ok {
-				m[string(key)] = []string{}
+			end := closeQuote(exp, i)
+			if end == -1 {
+				return nil, 0, newParseError(offset+i, exp[i:], []string{`"`}, "missing '\"' at the end of quoted value")
+			}
+			i = end
+		case '(', '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				params = append(params, newParam(exp[start:i], offset+start))
+				start = i + 1
 			}
+		case ')':
+			if depth == 0 {
+				params = append(params, newParam(exp[start:i], offset+start))
+				return params, i + 1, nil
+			}
+			depth--
+		}
+	}
+
+	return nil, 0, newParseError(offset+len(exp), stringsEmpty, []string{",", ")"}, "missing ')' at the end of parameters")
+}
 
-			for j := i + 1; j < len(exp); j++ {
-
-				if string(exp[j]) == "," || string(exp[j]) == "}" {
-					if len(value) == 0 {
-						return nil, errors.New(`map value cannot be empty`)
-					}
-					m[string(key)] = append(m[string(key)], string(value))
-					key = []byte{}
-					value = []byte{}
-					i = j
-					break
+// parseArgs - parses the parameters of a function which must be the last one in the expression
+func parseArgs(exp string, offset int) ([]param, error) {
+
+	params, end, err := parseParams(exp, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	if rest := strings.TrimSpace(exp[end:]); rest != stringsEmpty {
+		return nil, newParseError(offset+strings.Index(exp[end:], rest)+end, rest, nil, "unexpected %s after function parameters", rest)
+	}
+
+	return params, nil
+}
+
+// checkParams - checks the number of parameters parsed for a function
+func checkParams(function string, params []param, n int) error {
+
+	if len(params) > n {
+		return newParseError(params[n].offset, params[n].value, []string{")"}, "%s expects %d parameters but found %d: %v", function, n, len(params), params)
+	}
+
+	if len(params) < n {
+		last := params[len(params)-1]
+		return newParseError(last.offset+len(last.value), stringsEmpty, []string{","}, "%s expects %d parameters but found %d: %v", function, n, len(params), params)
+	}
+
+	return nil
+}
+
+// checkParamValue - checks if the parameter is one of the accepted values
+func checkParamValue(p param, accepted []string, name string) error {
+
+	v := p.atom()
+
+	for _, a := range accepted {
+		if a == v {
+			return nil
+		}
+	}
+
+	return newParseError(p.offset, p.value, accepted, "unknown %s %s", name, v)
+}
+
+// mapEntry - a key and value pair from a map
+type mapEntry struct {
+	key   param
+	value param
+}
+
+func parseMap(exp param) ([]mapEntry, error) {
+
+	if len(exp.value) == 0 {
+		return nil, newParseError(exp.offset, stringsEmpty, []string{"{"}, "empty map")
+	}
+
+	if exp.value[0] != '{' {
+		return nil, newParseError(exp.offset, exp.value, []string{"{"}, "missing '{' at the beginning of map")
+	}
+
+	if exp.value[len(exp.value)-1] != '}' {
+		return nil, newParseError(exp.offset+len(exp.value), stringsEmpty, []string{"}"}, "missing '}' at the end of map")
+	}
+
+	entries := []mapEntry{}
+
+	body := exp.value[1 : len(exp.value)-1]
+
+	depth := 0
+	start := 0
+
+	for i := 0; i <= len(body); i++ {
+
+		if i < len(body) {
+			switch body[i] {
+			case '"':
+				if !opensQuote(body, i) {
+					continue
+				}
+				end := closeQuote(body, i)
+				if end == -1 {
+					return nil, newParseError(exp.offset+1+i, body[i:], []string{`"`}, "missing '\"' at the end of quoted value")
+				}
+				i = end
+				continue
+			case '(', '{':
+				depth++
+				continue
+			case ')', '}':
+				depth--
+				continue
+			case ',':
+				if depth != 0 {
+					continue
 				}
-				value = append(value, exp[j])
+			default:
+				continue
 			}
-			continue
 		}
 
-		if string(exp[i]) == "," || string(exp[i]) == "}" {
-			return nil, errors.New(`bad map format`)
+		item := newParam(body[start:i], exp.offset+1+start)
+
+		eq := indexUnquoted(item.value, '=')
+		if eq == -1 {
+			return nil, newParseError(item.offset, item.value, []string{"="}, "bad map format")
+		}
+
+		entry := mapEntry{
+			key:   newParam(item.value[:eq], item.offset),
+			value: newParam(item.value[eq+1:], item.offset+eq+1),
+		}
+
+		if len(entry.key.value) == 0 {
+			return nil, newParseError(item.offset, item.value, []string{"<key>"}, "map key cannot be empty")
+		}
+
+		if len(entry.value.value) == 0 {
+			return nil, newParseError(entry.value.offset, item.value, []string{"<value>"}, "map value cannot be empty")
+		}
+
+		entries = append(entries, entry)
+		start = i + 1
+	}
+
+	return entries, nil
+}
+
+// parseTagFilters - parses a map of tag filters like {host=web01,app=or(a|b),dc=iliteral_or(SP)}, tag keys
+// and values can be quoted like {host=regexp("a{2,3}")} to hold characters with a meaning in expressions
+func parseTagFilters(exp param, groupBy bool) ([]Filter, error) {
+
+	entries, err := parseMap(exp)
+	if err != nil {
+		return nil, err
+	}
+
+	filters := []Filter{}
+
+	for _, entry := range entries {
+
+		var ft, cv string
+
+		v := entry.value.atom()
+
+		if strings.HasPrefix(v, "regexp(") && strings.HasSuffix(v, ")") {
+			ft = "regexp"
+			cv = v[7 : len(v)-1]
+		} else if strings.HasPrefix(v, "wildcard(") && strings.HasSuffix(v, ")") {
+			ft = "wildcard"
+			cv = v[9 : len(v)-1]
+		} else if strings.HasPrefix(v, "or(") && strings.HasSuffix(v, ")") {
+			ft = "literal_or"
+			cv = v[3 : len(v)-1]
+		} else if strings.HasPrefix(v, "notor(") && strings.HasSuffix(v, ")") {
+			ft = "not_literal_or"
+			cv = v[6 : len(v)-1]
+		} else if strings.HasPrefix(v, "iliteral_or(") && strings.HasSuffix(v, ")") {
+			ft = "iliteral_or"
+			cv = v[12 : len(v)-1]
+		} else if strings.HasPrefix(v, "not_iliteral_or(") && strings.HasSuffix(v, ")") {
+			ft = "not_iliteral_or"
+			cv = v[16 : len(v)-1]
+		} else if strings.HasPrefix(v, "iwildcard(") && strings.HasSuffix(v, ")") {
+			ft = "iwildcard"
+			cv = v[10 : len(v)-1]
+		} else {
+			ft = "wildcard"
+			cv = v
+		}
+
+		tagk, err := unquote(entry.key)
+		if err != nil {
+			return nil, err
+		}
+
+		cv, err = unquote(newParam(cv, entry.value.offset+strings.Index(v, cv)))
+		if err != nil {
+			return nil, err
 		}
 
-		key = append(key, exp[i])
+		filters = append(filters, Filter{
+			Ftype:   ft,
+			Tagk:    tagk,
+			Filter:  cv,
+			GroupBy: groupBy,
+		})
 	}
 
-	return m, nil
+	return filters, nil
 }
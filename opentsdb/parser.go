@@ -1,13 +1,38 @@
 package opentsdb
 
 import (
-	"fmt"
 	"strings"
+	"unicode"
 )
 
+// expressionFunctions - the functions accepted by the expression parser
+var expressionFunctions = []string{
+	"query",
+	"merge",
+	"downsample",
+	"groupBy",
+	"rate",
+	"filter",
+	"topN",
+	"bottomN",
+}
+
 // ParseExpression - parses a timeseries query expression and returns a TSDB query struct with the expression values
 func ParseExpression(exp string, tsdb *Expression) (relative string, err error) {
-	relative, err = parseExpression(exp, tsdb)
+	node, err := ParseAST(exp)
+	if err != nil {
+		return stringsEmpty, err
+	}
+	relative, err = lowerExpression(node, tsdb)
+	if perr, ok := err.(*ParseError); ok {
+		perr.locate(exp)
+	}
+	return relative, err
+}
+
+// lowerExpression - lowers the tree into the TSDB query struct, keeping in the order array only the operations
+func lowerExpression(node Node, tsdb *Expression) (relative string, err error) {
+	relative, err = LowerAST(node, tsdb)
 	if err != nil {
 		return relative, err
 	}
@@ -21,51 +46,62 @@ func ParseExpression(exp string, tsdb *Expression) (relative string, err error)
 	return relative, nil
 }
 
-func parseExpression(exp string, tsdb *Expression) (relative string, err error) {
+func parseExpression(exp string, offset int) (Node, error) {
 
-	var name []rune
+	trimmed := strings.TrimLeftFunc(exp, unicode.IsSpace)
+	offset += len(exp) - len(trimmed)
+	exp = strings.TrimRightFunc(trimmed, unicode.IsSpace)
 
-	exp = strings.Replace(exp, stringsWhiteSpace, stringsEmpty, -1)
+	if exp == stringsEmpty {
+		return nil, newParseError(offset, stringsEmpty, expressionFunctions, "missing expression")
+	}
 
-	for _, s := range exp {
-		if string(s) == "(" {
-			break
-		}
-		name = append(name, s)
+	i := strings.IndexByte(exp, '(')
+	if i == -1 {
+		return nil, newParseError(offset, exp, expressionFunctions, "unknown function %s", removeSpaces(exp))
 	}
 
-	switch string(name) {
+	name := removeSpaces(exp[:i])
+
+	var node Node
+	var err error
+
+	switch name {
 	case "query":
-		relative, err = parseQuery(exp, tsdb)
-		exp = stringsEmpty
+		node, err = parseQuery(exp[i:], offset+i)
 	case "merge":
-		exp, err = parseMerge(exp, tsdb)
+		node, err = parseMerge(exp[i:], offset+i)
 	case "downsample":
-		exp, err = parseDownsample(exp, tsdb)
+		node, err = parseDownsample(exp[i:], offset+i)
 	case "groupBy":
-		exp, err = parseGroup(exp, tsdb)
+		node, err = parseGroup(exp[i:], offset+i)
 	case "rate":
-		exp, err = parseRate(exp, tsdb)
+		node, err = parseRate(exp[i:], offset+i)
 	case "filter":
-		exp, err = parseFilter(exp, tsdb)
+		node, err = parseFilter(exp[i:], offset+i)
+	case "topN":
+		node, err = parseTopN(exp[i:], offset+i, false)
+	case "bottomN":
+		node, err = parseTopN(exp[i:], offset+i, true)
 	default:
-		return stringsEmpty, fmt.Errorf("unknown function %s", string(name))
+		return nil, newParseError(offset, name, expressionFunctions, "unknown function %s", name)
 	}
+
 	if err != nil {
-		return stringsEmpty, err
-	}
-	if exp != stringsEmpty {
-		relative, err = parseExpression(exp, tsdb)
+		return nil, err
 	}
 
-	return relative, err
+	node.(positioned).setOffset(offset)
+
+	return node, nil
 }
 
-// CompileExpression - writes an expression given a TSDB query struct
+// CompileExpression - writes an expression given a TSDB query struct, the expressions are written from
+// the canonical form of the query so parsing them gives back the normalized query expressions
 func CompileExpression(tsQueries []Query) (exps []string) {
 
 	for _, tsQuery := range tsQueries {
-		for _, query := range tsQuery.Queries {
+		for _, query := range tsQuery.Normalize().Queries {
 
 			exp := writeQuery(query.Metric, tsQuery.Relative, query.Filters)
 
@@ -75,11 +111,13 @@ func CompileExpression(tsQueries []Query) (exps []string) {
 				case "aggregation":
 					exp = writeMerge(exp, query.Aggregator)
 				case "downsample":
-					exp = writeDownsample(exp, query.Downsample)
+					exp = writeDownsample(exp, query.Downsample, query.Timezone)
 				case "rate":
 					exp = writeRate(exp, query.Rate, query.RateOptions)
 				case "filterValue":
 					exp = writeFilter(exp, query.FilterValue)
+				case "topN":
+					exp = writeTopN(exp, query.TopN)
 				}
 
 			}
@@ -0,0 +1,159 @@
+package opentsdb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PointRules - the write rules checked by the point validation
+type PointRules struct {
+	// MaxTags - the maximum number of tags, not counting the keyset and TTL, zero means no limit
+	MaxTags int
+
+	// AllowedTTLs - the accepted TTL values besides zero (the default TTL), empty means any positive TTL
+	AllowedTTLs []int
+}
+
+// DefaultPointRules - the rules used by Mycenae when writing points
+var DefaultPointRules = PointRules{
+	MaxTags: 20,
+}
+
+// PointError - the violations found in a point from a batch
+type PointError struct {
+	Index  int              `json:"index"`
+	Errors ValidationErrors `json:"errors"`
+}
+
+// Error - returns the violations prefixed by the point index
+func (e *PointError) Error() string {
+	return fmt.Sprintf("point %d: %s", e.Index, e.Errors.Error())
+}
+
+// PointErrors - the points with violations from a batch
+type PointErrors []*PointError
+
+// Error - returns all point errors separated by a semicolon
+func (e PointErrors) Error() string {
+
+	msgs := make([]string, len(e))
+
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// Validate - validates the point against the write rules, returning every violation found as ValidationErrors
+func (point *Point) Validate(rules PointRules) error {
+
+	v := validator{all: true}
+
+	point.validate(&v, rules)
+
+	if len(v.errs) == 0 {
+		return nil
+	}
+
+	return v.errs
+}
+
+func (point *Point) validate(v *validator, rules PointRules) {
+
+	query := Query{}
+
+	if point.Metric == stringsEmpty {
+		v.check("metric", newValidationError(CodeRequired, nil, "metric is required"))
+	} else {
+		v.check("metric", query.checkField("metric", point.Metric))
+	}
+
+	if point.Keyset == stringsEmpty {
+		v.check("keyset", newValidationError(CodeRequired, nil, "keyset is required"))
+	} else {
+		v.check("keyset", query.checkField("keyset", point.Keyset))
+	}
+
+	if point.Value == nil && point.Text == stringsEmpty {
+		v.check("value", newValidationError(CodeInvalidValue, nil, "a number value or a text is required"))
+	} else if point.Value != nil && point.Text != stringsEmpty {
+		v.check("value", newValidationError(CodeInvalidValue, *point.Value, "a point cannot have both a number value and a text"))
+	}
+
+	if rules.MaxTags > 0 && len(point.Tags) > rules.MaxTags {
+		v.check("tags", newValidationError(CodeTooManyTags, len(point.Tags), "the maximum number of tags is %d but found %d", rules.MaxTags, len(point.Tags)))
+	}
+
+	names := make(map[string]bool, len(point.Tags))
+
+	for i, tag := range point.Tags {
+
+		path := fmt.Sprintf("tags[%d]", i)
+
+		v.check(path+".name", query.checkField("tag name", tag.Name))
+		v.check(path+".value", query.checkField("tag value", tag.Value))
+
+		if tag.Name == putKSIDTag || tag.Name == putTTLTag {
+			v.check(path+".name", newValidationError(CodeInvalidTag, tag.Name, "tag %s is reserved", tag.Name))
+		}
+
+		if names[tag.Name] {
+			v.check(path+".name", newValidationError(CodeInvalidTag, tag.Name, "duplicated tag %s", tag.Name))
+		}
+
+		names[tag.Name] = true
+	}
+
+	if point.TTL < 0 {
+		v.check("ttl", newValidationError(CodeInvalidTTL, point.TTL, "ttl cannot be negative"))
+	} else if point.TTL > 0 && len(rules.AllowedTTLs) > 0 {
+
+		allowed := false
+
+		for _, ttl := range rules.AllowedTTLs {
+			if ttl == point.TTL {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			v.check("ttl", newValidationError(CodeInvalidTTL, point.TTL, "ttl %d is not one of the allowed values %v", point.TTL, rules.AllowedTTLs))
+		}
+	}
+}
+
+// Validate - validates every point against the write rules, returning the points with violations as PointErrors
+func (points Points) Validate(rules PointRules) error {
+
+	errs := PointErrors{}
+
+	for i, point := range points {
+
+		if point == nil {
+			errs = append(errs, &PointError{
+				Index:  i,
+				Errors: ValidationErrors{newValidationError(CodeRequired, nil, "point cannot be null")},
+			})
+			continue
+		}
+
+		v := validator{all: true}
+
+		point.validate(&v, rules)
+
+		if len(v.errs) > 0 {
+			errs = append(errs, &PointError{
+				Index:  i,
+				Errors: v.errs,
+			})
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
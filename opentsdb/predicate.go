@@ -0,0 +1,458 @@
+package opentsdb
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The filter value keywords and operators
+const (
+	valueAnd      string = "&&"
+	valueOr       string = "||"
+	valueNot      string = "!"
+	valueRange    string = ".."
+	valueIsNaN    string = "isnan"
+	valueOpenPar  string = "("
+	valueClosePar string = ")"
+)
+
+// valueOperators - the comparison operators, the two characters ones first
+var valueOperators = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// valueExpected - the tokens that can start a filter value condition
+var valueExpected = []string{">=", "<=", "==", "!=", ">", "<", "<number>..<number>", valueIsNaN, valueNot, valueOpenPar}
+
+// ValueMatcher - a compiled filter value condition, like >=10, 10..20, >=10&&<20||isnan or !(<0||>100)
+type ValueMatcher struct {
+	Condition string
+	root      valuePredicate
+}
+
+// valuePredicate - a node of a filter value condition
+type valuePredicate interface {
+	match(v float64) bool
+	precedence() int
+	write(b *strings.Builder)
+}
+
+// CompileFilterValue - compiles a filter value condition, comparisons and inclusive ranges like 10..20 can be
+// combined with && and ||, negated with ! and grouped with parentheses, isnan matches the NaN values
+func CompileFilterValue(condition string) (*ValueMatcher, error) {
+
+	root, err := parseValuePredicate(condition, 0)
+	if err != nil {
+		err.locate(condition)
+		return nil, err
+	}
+
+	return &ValueMatcher{
+		Condition: condition,
+		root:      root,
+	}, nil
+}
+
+// Match - checks if the value matches the condition
+func (m *ValueMatcher) Match(v float64) bool {
+	return m.root.match(v)
+}
+
+// String - returns the condition in its canonical form, without spaces and redundant parentheses
+func (m *ValueMatcher) String() string {
+
+	b := strings.Builder{}
+
+	m.root.write(&b)
+
+	return b.String()
+}
+
+// valueParser - a recursive descent parser of filter value conditions
+type valueParser struct {
+	exp    string
+	pos    int
+	offset int
+}
+
+// parseValuePredicate - parses the condition, the error offsets are added to offset
+func parseValuePredicate(exp string, offset int) (valuePredicate, *ParseError) {
+
+	p := &valueParser{exp: exp, offset: offset}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.skipSpaces(); p.pos < len(p.exp) {
+		return nil, p.errorf([]string{valueAnd, valueOr}, "unexpected content in filter value")
+	}
+
+	return root, nil
+}
+
+func (p *valueParser) parseOr() (valuePredicate, *ParseError) {
+
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	or := valueOrPredicate{left}
+
+	for p.consume(valueOr) {
+
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		or = append(or, right)
+	}
+
+	if len(or) == 1 {
+		return left, nil
+	}
+
+	return or, nil
+}
+
+func (p *valueParser) parseAnd() (valuePredicate, *ParseError) {
+
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	and := valueAndPredicate{left}
+
+	for p.consume(valueAnd) {
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		and = append(and, right)
+	}
+
+	if len(and) == 1 {
+		return left, nil
+	}
+
+	return and, nil
+}
+
+func (p *valueParser) parseUnary() (valuePredicate, *ParseError) {
+
+	p.skipSpaces()
+
+	for _, op := range valueOperators {
+		if p.consume(op) {
+
+			n, err := p.parseNumber()
+			if err != nil {
+				return nil, err
+			}
+
+			return valueComparison{operator: op, value: n}, nil
+		}
+	}
+
+	if p.consume(valueNot) {
+
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return valueNotPredicate{operand}, nil
+	}
+
+	if p.consume(valueOpenPar) {
+
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if !p.consume(valueClosePar) {
+			return nil, p.errorf([]string{valueClosePar}, "unclosed parenthesis in filter value")
+		}
+
+		return inner, nil
+	}
+
+	if p.consume(valueIsNaN) {
+		return valueIsNaNPredicate{}, nil
+	}
+
+	if p.pos < len(p.exp) && isNumberStart(p.exp[p.pos]) {
+
+		start := p.pos
+
+		from, err := p.parseNumber()
+		if err != nil {
+			return nil, err
+		}
+
+		if !p.consume(valueRange) {
+			return nil, p.errorf([]string{valueRange}, "a number in a filter value must be the start of a range")
+		}
+
+		to, err := p.parseNumber()
+		if err != nil {
+			return nil, err
+		}
+
+		if from > to {
+			return nil, newParseError(p.offset+start, p.exp[start:p.pos], nil, "range start must not be greater than its end")
+		}
+
+		return valueRangePredicate{from: from, to: to}, nil
+	}
+
+	return nil, p.errorf(valueExpected, "invalid filter value")
+}
+
+// parseNumber - parses a number, stopping before a range separator
+func (p *valueParser) parseNumber() (float64, *ParseError) {
+
+	p.skipSpaces()
+
+	end := scanNumber(p.exp, p.pos)
+
+	n, err := strconv.ParseFloat(p.exp[p.pos:end], 64)
+	if err != nil {
+		return 0, p.errorf([]string{"<number>"}, "invalid number in filter value")
+	}
+
+	p.pos = end
+
+	return n, nil
+}
+
+// consume - skips the token when it is next, ignoring spaces
+func (p *valueParser) consume(token string) bool {
+
+	p.skipSpaces()
+
+	if strings.HasPrefix(p.exp[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+
+	return false
+}
+
+func (p *valueParser) skipSpaces() {
+	for p.pos < len(p.exp) && (p.exp[p.pos] == ' ' || p.exp[p.pos] == '\t' || p.exp[p.pos] == '\n' || p.exp[p.pos] == '\r') {
+		p.pos++
+	}
+}
+
+// errorf - returns a parse error at the current position, with the rest of the condition as the token
+func (p *valueParser) errorf(expected []string, format string, args ...interface{}) *ParseError {
+
+	p.skipSpaces()
+
+	token := p.exp[p.pos:]
+	if end := strings.IndexAny(token, " &|()"); end > 0 {
+		token = token[:end]
+	}
+
+	return newParseError(p.offset+p.pos, token, expected, format, args...)
+}
+
+// scanNumber - returns the end of the number starting at i, like -1.5e3, stopping before a range separator
+func scanNumber(s string, i int) int {
+
+	if i < len(s) && (s[i] == '-' || s[i] == '+') {
+		i++
+	}
+
+	digits := func() {
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+	}
+
+	digits()
+
+	if i < len(s) && s[i] == '.' && !strings.HasPrefix(s[i:], valueRange) {
+		i++
+		digits()
+	}
+
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '-' || s[i] == '+') {
+			i++
+		}
+		digits()
+	}
+
+	return i
+}
+
+func isNumberStart(c byte) bool {
+	return c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9')
+}
+
+func formatValue(n float64) string {
+	return strconv.FormatFloat(n, 'g', -1, 64)
+}
+
+// valueComparison - a comparison like >=10
+type valueComparison struct {
+	operator string
+	value    float64
+}
+
+func (c valueComparison) match(v float64) bool {
+
+	switch c.operator {
+	case ">=":
+		return v >= c.value
+	case "<=":
+		return v <= c.value
+	case "==":
+		return v == c.value
+	case "!=":
+		return v != c.value
+	case ">":
+		return v > c.value
+	}
+
+	return v < c.value
+}
+
+func (c valueComparison) precedence() int {
+	return 3
+}
+
+func (c valueComparison) write(b *strings.Builder) {
+	b.WriteString(c.operator)
+	b.WriteString(formatValue(c.value))
+}
+
+// valueRangePredicate - an inclusive range like 10..20
+type valueRangePredicate struct {
+	from, to float64
+}
+
+func (r valueRangePredicate) match(v float64) bool {
+	return v >= r.from && v <= r.to
+}
+
+func (r valueRangePredicate) precedence() int {
+	return 3
+}
+
+func (r valueRangePredicate) write(b *strings.Builder) {
+	b.WriteString(formatValue(r.from))
+	b.WriteString(valueRange)
+	b.WriteString(formatValue(r.to))
+}
+
+// valueIsNaNPredicate - matches the NaN values
+type valueIsNaNPredicate struct{}
+
+func (valueIsNaNPredicate) match(v float64) bool {
+	return math.IsNaN(v)
+}
+
+func (valueIsNaNPredicate) precedence() int {
+	return 3
+}
+
+func (valueIsNaNPredicate) write(b *strings.Builder) {
+	b.WriteString(valueIsNaN)
+}
+
+// valueNotPredicate - negates a condition
+type valueNotPredicate struct {
+	operand valuePredicate
+}
+
+func (n valueNotPredicate) match(v float64) bool {
+	return !n.operand.match(v)
+}
+
+func (n valueNotPredicate) precedence() int {
+	return 3
+}
+
+func (n valueNotPredicate) write(b *strings.Builder) {
+	b.WriteString(valueNot)
+	writeValueOperand(b, n.operand, 3)
+}
+
+// valueAndPredicate - matches when all conditions match
+type valueAndPredicate []valuePredicate
+
+func (and valueAndPredicate) match(v float64) bool {
+
+	for _, p := range and {
+		if !p.match(v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (and valueAndPredicate) precedence() int {
+	return 2
+}
+
+func (and valueAndPredicate) write(b *strings.Builder) {
+
+	for i, p := range and {
+		if i > 0 {
+			b.WriteString(valueAnd)
+		}
+		writeValueOperand(b, p, 2)
+	}
+}
+
+// valueOrPredicate - matches when any condition matches
+type valueOrPredicate []valuePredicate
+
+func (or valueOrPredicate) match(v float64) bool {
+
+	for _, p := range or {
+		if p.match(v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (or valueOrPredicate) precedence() int {
+	return 1
+}
+
+func (or valueOrPredicate) write(b *strings.Builder) {
+
+	for i, p := range or {
+		if i > 0 {
+			b.WriteString(valueOr)
+		}
+		writeValueOperand(b, p, 1)
+	}
+}
+
+// writeValueOperand - writes the operand, with parentheses when it binds less than its parent
+func writeValueOperand(b *strings.Builder, p valuePredicate, parent int) {
+
+	if p.precedence() >= parent {
+		p.write(b)
+		return
+	}
+
+	b.WriteString(valueOpenPar)
+	p.write(b)
+	b.WriteString(valueClosePar)
+}
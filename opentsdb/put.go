@@ -0,0 +1,229 @@
+package opentsdb
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+)
+
+const (
+	putCommand string = "put"
+	putKSIDTag string = "ksid"
+	putTTLTag  string = "ttl"
+)
+
+// The point fields reported by PutLineError
+const (
+	PutFieldCommand   string = "command"
+	PutFieldMetric    string = "metric"
+	PutFieldTimestamp string = "timestamp"
+	PutFieldValue     string = "value"
+	PutFieldTag       string = "tag"
+)
+
+// ParsePutLine - parses a telnet style "put <metric> <timestamp> <value> <tagk=tagv>..." line into the point,
+// the ksid and ttl tags are set as the point keyset and TTL. The point tags slice and value are reused and
+// strings are only allocated when they differ from the ones already in the point, so a point must not be
+// kept by the caller between calls.
+func (point *Point) ParsePutLine(line []byte) error {
+
+	line = bytes.TrimRight(line, "\r\n")
+
+	start, end := nextPutField(line, 0)
+	if string(line[start:end]) != putCommand {
+		return newPutLineError(PutFieldCommand, start, line[start:end], "line must start with put")
+	}
+
+	start, end = nextPutField(line, end)
+	if start == end {
+		return newPutLineError(PutFieldMetric, start, nil, "missing metric")
+	}
+	setPutString(&point.Metric, line[start:end])
+
+	start, end = nextPutField(line, end)
+	if start == end {
+		return newPutLineError(PutFieldTimestamp, start, nil, "missing timestamp")
+	}
+	if err := point.parsePutTimestamp(line[start:end]); err != nil {
+		return newPutLineError(PutFieldTimestamp, start, line[start:end], err.Error())
+	}
+
+	start, end = nextPutField(line, end)
+	if start == end {
+		return newPutLineError(PutFieldValue, start, nil, "missing value")
+	}
+	value, err := strconv.ParseFloat(string(line[start:end]), 64)
+	if err != nil {
+		return newPutLineError(PutFieldValue, start, line[start:end], "value must be a number")
+	}
+	if point.Value == nil {
+		point.Value = new(float64)
+	}
+	*point.Value = value
+	point.Text = stringsEmpty
+
+	point.Tags = point.Tags[:0]
+	point.TTL = 0
+
+	hasKeyset := false
+
+	for {
+
+		start, end = nextPutField(line, end)
+		if start == end {
+			break
+		}
+
+		isKeyset, err := point.parsePutTag(line[start:end])
+		if err != nil {
+			return newPutLineError(PutFieldTag, start, line[start:end], err.Error())
+		}
+
+		hasKeyset = hasKeyset || isKeyset
+	}
+
+	if !hasKeyset {
+		point.Keyset = stringsEmpty
+	}
+
+	return nil
+}
+
+func (point *Point) parsePutTimestamp(field []byte) error {
+
+	if len(field) != 10 && len(field) != 13 {
+		return errors.New("timestamp must have 10 digits in seconds or 13 digits in milliseconds")
+	}
+
+	var ts int64
+
+	for _, c := range field {
+		if c < '0' || c > '9' {
+			return errors.New("timestamp must contain only digits")
+		}
+		ts = ts*10 + int64(c-'0')
+	}
+
+	point.Timestamp = ts
+
+	return nil
+}
+
+// parsePutTag - parses a tagk=tagv field, returning true when it is the keyset tag
+func (point *Point) parsePutTag(field []byte) (bool, error) {
+
+	eq := bytes.IndexByte(field, '=')
+
+	if eq == -1 {
+		return false, errors.New("tag must be in the tagk=tagv format")
+	}
+
+	if eq == 0 {
+		return false, errors.New("tag key cannot be empty")
+	}
+
+	if eq == len(field)-1 {
+		return false, errors.New("tag value cannot be empty")
+	}
+
+	key, value := field[:eq], field[eq+1:]
+
+	switch string(key) {
+	case putKSIDTag:
+		setPutString(&point.Keyset, value)
+		return true, nil
+	case putTTLTag:
+		ttl, err := strconv.Atoi(string(value))
+		if err != nil {
+			return false, errors.New("ttl must be an integer")
+		}
+		point.TTL = ttl
+		return false, nil
+	}
+
+	if len(point.Tags) < cap(point.Tags) {
+		point.Tags = point.Tags[:len(point.Tags)+1]
+	} else {
+		point.Tags = append(point.Tags, Tag{})
+	}
+
+	tag := &point.Tags[len(point.Tags)-1]
+	setPutString(&tag.Name, key)
+	setPutString(&tag.Value, value)
+
+	return false, nil
+}
+
+// AppendPutLine - appends the point as a telnet style put line, without the trailing new line
+func (point *Point) AppendPutLine(dst []byte) ([]byte, error) {
+
+	if point.Value == nil {
+		return dst, errors.New("only points with a numeric value can be written as a put line")
+	}
+
+	dst = append(dst, putCommand...)
+	dst = append(dst, ' ')
+	dst = append(dst, point.Metric...)
+	dst = append(dst, ' ')
+	dst = strconv.AppendInt(dst, point.Timestamp, 10)
+	dst = append(dst, ' ')
+	dst = strconv.AppendFloat(dst, *point.Value, 'f', -1, 64)
+
+	for _, tag := range point.Tags {
+		dst = appendPutTag(dst, tag.Name, tag.Value)
+	}
+
+	if point.Keyset != stringsEmpty {
+		dst = appendPutTag(dst, putKSIDTag, point.Keyset)
+	}
+
+	if point.TTL != 0 {
+		dst = append(dst, ' ')
+		dst = append(dst, putTTLTag...)
+		dst = append(dst, '=')
+		dst = strconv.AppendInt(dst, int64(point.TTL), 10)
+	}
+
+	return dst, nil
+}
+
+// PutLine - returns the point as a telnet style put line, without the trailing new line
+func (point *Point) PutLine() (string, error) {
+
+	line, err := point.AppendPutLine(nil)
+	if err != nil {
+		return stringsEmpty, err
+	}
+
+	return string(line), nil
+}
+
+func appendPutTag(dst []byte, key, value string) []byte {
+	dst = append(dst, ' ')
+	dst = append(dst, key...)
+	dst = append(dst, '=')
+	return append(dst, value...)
+}
+
+// nextPutField - returns the bounds of the next field separated by spaces or tabs
+func nextPutField(line []byte, i int) (int, int) {
+
+	for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+		i++
+	}
+
+	start := i
+
+	for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+		i++
+	}
+
+	return start, i
+}
+
+// setPutString - sets the string only when it differs from the bytes, avoiding an allocation otherwise
+func setPutString(s *string, b []byte) {
+	if *s != string(b) {
+		*s = string(b)
+	}
+}
@@ -1,165 +1,147 @@
 package opentsdb
 
 import (
-	"errors"
 	"fmt"
 	"sort"
 	"strings"
 )
 
-func parseQuery(exp string, tsdb *Expression) (e string, err error) {
+// QueryNode - the query(metric,{tags},relative) function
+type QueryNode struct {
+	position
 
-	params := parseParams(string(exp[5:]))
+	Metric   string
+	Filters  []Filter
+	Relative string
+}
 
-	if len(params) != 3 {
-		return stringsEmpty, fmt.Errorf("query expects 3 parameters but found %d: %v", len(params), params)
-	}
+// Function - returns the expression function name of the node
+func (n *QueryNode) Function() string {
+	return "query"
+}
 
-	tags := map[string][]string{}
+// Children - returns the nodes nested inside this node
+func (n *QueryNode) Children() []Node {
+	return nil
+}
 
-	if params[1] != "null" {
-		tags, err = parseMap(params[1])
-		if err != nil {
-			return stringsEmpty, err
-		}
+// String - writes the node as an expression
+func (n *QueryNode) String() string {
+	return writeQuery(n.Metric, n.Relative, n.Filters)
+}
+
+func (n *QueryNode) lower(tsdb *Expression) (string, error) {
+
+	if hasOperation(tsdb, "query") {
+		return stringsEmpty, newDuplicateError(n, n.position, tsdb, "found more than one 'query' function")
 	}
 
-	tsdb.Metric = params[0]
+	tsdb.Metric = n.Metric
 
 	tsdb.Tags = map[string]string{}
 
-	for k, vs := range tags {
-		for _, v := range vs {
-
-			var ft, cv string
-
-			if strings.HasPrefix(v, "regexp(") && strings.HasSuffix(v, ")") {
-				ft = "regexp"
-				cv = v[7 : len(v)-1]
-			} else if strings.HasPrefix(v, "wildcard(") && strings.HasSuffix(v, ")") {
-				ft = "wildcard"
-				cv = v[9 : len(v)-1]
-			} else if strings.HasPrefix(v, "or(") && strings.HasSuffix(v, ")") {
-				ft = "literal_or"
-				cv = v[3 : len(v)-1]
-			} else if strings.HasPrefix(v, "notor(") && strings.HasSuffix(v, ")") {
-				ft = "not_literal_or"
-				cv = v[6 : len(v)-1]
-			} else {
-				ft = "wildcard"
-				cv = v
-			}
-
-			filter := Filter{
-				Ftype:   ft,
-				Tagk:    k,
-				Filter:  cv,
-				GroupBy: false,
-			}
-			tsdb.Filters = append(tsdb.Filters, filter)
-		}
+	tsdb.Filters = append(tsdb.Filters, n.Filters...)
+
+	tsdb.Order = append(tsdb.Order, "query")
+
+	return n.Relative, nil
+}
+
+func parseQuery(exp string, offset int) (Node, error) {
+
+	params, err := parseArgs(exp, offset)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, oper := range tsdb.Order {
-		if oper == "query" {
-			return stringsEmpty, errors.New("found more than one 'query' function")
-		}
+	if err := checkParams("query", params, 3); err != nil {
+		return nil, err
+	}
+
+	node := &QueryNode{
+		Metric:   params[0].atom(),
+		Filters:  []Filter{},
+		Relative: params[2].atom(),
 	}
 
-	tsdb.Order = append([]string{"query"}, tsdb.Order...)
+	if params[1].atom() != "null" {
+		node.Filters, err = parseTagFilters(params[1], false)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	return params[2], nil
+	return node, nil
 }
 
 func writeQuery(metric, relative string, filters []Filter) string {
 
-	exp := fmt.Sprintf("query(%s,", metric)
-
-	if len(filters) == 0 {
-		exp = fmt.Sprintf("%snull,", exp)
-	} else {
-
-		orderedTags := []string{}
-
-		joinFilters := map[string][]string{}
-
-		for _, filter := range filters {
-			if !filter.GroupBy {
-				if _, ok := joinFilters[filter.Tagk]; !ok {
-					switch filter.Ftype {
-					case "wildcard":
-						joinFilters[filter.Tagk] = []string{
-							filter.Filter,
-						}
-					case "regexp":
-						joinFilters[filter.Tagk] = []string{
-							fmt.Sprintf("%s(%s)", filter.Ftype, filter.Filter),
-						}
-					case "literal_or":
-						joinFilters[filter.Tagk] = []string{
-							fmt.Sprintf("or(%s)", filter.Filter),
-						}
-					case "not_literal_or":
-						joinFilters[filter.Tagk] = []string{
-							fmt.Sprintf("notor(%s)", filter.Filter),
-						}
-					}
-					orderedTags = append(orderedTags, filter.Tagk)
-				} else {
-					switch filter.Ftype {
-					case "wildcard":
-						joinFilters[filter.Tagk] = append(
-							joinFilters[filter.Tagk],
-							filter.Filter,
-						)
-					case "regexp":
-						joinFilters[filter.Tagk] = append(
-							joinFilters[filter.Tagk],
-							fmt.Sprintf("%s(%s)", filter.Ftype, filter.Filter),
-						)
-					case "literal_or":
-						joinFilters[filter.Tagk] = append(
-							joinFilters[filter.Tagk],
-							fmt.Sprintf("or(%s)", filter.Ftype),
-						)
-						joinFilters[filter.Tagk] = []string{
-							fmt.Sprintf("or(%s)", filter.Filter),
-						}
-					case "not_literal_or":
-						joinFilters[filter.Tagk] = append(
-							joinFilters[filter.Tagk],
-							fmt.Sprintf("notor(%s)", filter.Ftype),
-						)
-					}
-				}
-			}
-		}
+	tags := writeTagFilters(filters, false)
 
-		if len(orderedTags) == 0 {
-			exp = fmt.Sprintf("%snull,", exp)
-		} else {
+	if tags == stringsEmpty {
+		tags = "null"
+	}
 
-			expMap := fmt.Sprintf("%s{", exp)
+	return fmt.Sprintf("query(%s,%s,%s)", metric, tags, relative)
+}
 
-			sort.Strings(orderedTags)
+// writeTagFilter - writes the value of a tag filter as it is read by parseTagFilters, quoted when needed
+func writeTagFilter(filter Filter) string {
 
-			for _, tk := range orderedTags {
+	switch filter.Ftype {
+	case "wildcard":
+		return quote(filter.Filter)
+	case "literal_or":
+		return fmt.Sprintf("or(%s)", quote(filter.Filter))
+	case "not_literal_or":
+		return fmt.Sprintf("notor(%s)", quote(filter.Filter))
+	}
 
-				sort.Strings(joinFilters[tk])
+	return fmt.Sprintf("%s(%s)", filter.Ftype, quote(filter.Filter))
+}
 
-				for _, fv := range joinFilters[tk] {
-					expMap = fmt.Sprintf("%s%s=%s,", expMap, tk, fv)
-				}
-			}
+// writeTagFilters - writes the map of the query or group by filters sorted by tag key and value,
+// returning an empty string when there are none
+func writeTagFilters(filters []Filter, groupBy bool) string {
 
-			expMap = expMap[:len(expMap)-1]
+	tags := []string{}
 
-			exp = fmt.Sprintf("%s},", expMap)
+	for _, filter := range sortTagFilters(filters) {
+		if filter.GroupBy == groupBy {
+			tags = append(tags, fmt.Sprintf("%s=%s", quote(filter.Tagk), writeTagFilter(filter)))
 		}
+	}
 
+	if len(tags) == 0 {
+		return stringsEmpty
 	}
 
-	exp = fmt.Sprintf("%s%s)", exp, relative)
+	return fmt.Sprintf("{%s}", strings.Join(tags, ","))
+}
+
+// sortTagFilters - returns a copy of the filters without duplicates, the query filters first and
+// then the group by ones, each sorted by tag key and value like they are written in an expression
+func sortTagFilters(filters []Filter) []Filter {
+
+	sorted := []Filter{}
+	seen := map[Filter]bool{}
+
+	for _, filter := range filters {
+		if !seen[filter] {
+			seen[filter] = true
+			sorted = append(sorted, filter)
+		}
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].GroupBy != sorted[j].GroupBy {
+			return !sorted[i].GroupBy
+		}
+		if sorted[i].Tagk != sorted[j].Tagk {
+			return sorted[i].Tagk < sorted[j].Tagk
+		}
+		return writeTagFilter(sorted[i]) < writeTagFilter(sorted[j])
+	})
 
-	return exp
+	return sorted
 }
@@ -1,50 +1,91 @@
 package opentsdb
 
 import (
-	"errors"
 	"fmt"
+	"math"
 	"strconv"
 )
 
-func parseRate(exp string, tsdb *Expression) (string, error) {
+// RateNode - the rate(counter,counterMax,resetValue,expression) function
+type RateNode struct {
+	position
 
-	params := parseParams(string(exp[4:]))
+	Options Rate
+	Child   Node
+}
+
+// Function - returns the expression function name of the node
+func (n *RateNode) Function() string {
+	return "rate"
+}
+
+// Children - returns the nodes nested inside this node
+func (n *RateNode) Children() []Node {
+	return []Node{n.Child}
+}
+
+// String - writes the node and its children as an expression
+func (n *RateNode) String() string {
+	return writeRate(n.Child.String(), true, n.Options)
+}
+
+func (n *RateNode) lower(tsdb *Expression) (string, error) {
+
+	relative, err := n.Child.lower(tsdb)
+	if err != nil {
+		return relative, err
+	}
 
-	if len(params) != 4 {
-		return stringsEmpty, fmt.Errorf("rate expects 4 parameters but found %d: %v", len(params), params)
+	if hasOperation(tsdb, "rate") {
+		return stringsEmpty, newDuplicateError(n, n.position, tsdb, "found more than one 'rate' function")
 	}
 
-	b, err := strconv.ParseBool(params[0])
+	tsdb.Rate = true
+
+	tsdb.RateOptions = n.Options
+
+	tsdb.Order = append(tsdb.Order, "rate")
+
+	return relative, nil
+}
+
+func parseRate(exp string, offset int) (Node, error) {
+
+	params, err := parseArgs(exp, offset)
 	if err != nil {
-		return stringsEmpty, err
+		return nil, err
 	}
 
-	tsdb.RateOptions.Counter = b
+	if err := checkParams("rate", params, 4); err != nil {
+		return nil, err
+	}
+
+	node := &RateNode{}
+
+	node.Options.Counter, err = strconv.ParseBool(params[0].atom())
+	if err != nil {
+		return nil, newParseError(params[0].offset, params[0].value, []string{"true", "false"}, "invalid rate counter")
+	}
 
-	if params[1] != "null" {
-		counterMax, err := strconv.ParseInt(params[1], 10, 64)
+	if params[1].atom() != "null" {
+		counterMax, err := strconv.ParseInt(params[1].atom(), 10, 64)
 		if err != nil {
-			return stringsEmpty, err
+			return nil, newParseError(params[1].offset, params[1].value, []string{"null", "<integer>"}, "invalid rate counter max")
 		}
-		tsdb.RateOptions.CounterMax = &counterMax
+		node.Options.CounterMax = &counterMax
 	}
 
-	tsdb.RateOptions.ResetValue, err = strconv.ParseInt(params[2], 10, 64)
+	node.Options.ResetValue, err = strconv.ParseInt(params[2].atom(), 10, 64)
 	if err != nil {
-		return stringsEmpty, err
+		return nil, newParseError(params[2].offset, params[2].value, []string{"<integer>"}, "invalid rate reset value")
 	}
 
-	tsdb.Rate = true
-
-	for _, oper := range tsdb.Order {
-		if oper == "rate" {
-			return stringsEmpty, errors.New("found more than one 'rate' function")
-		}
+	node.Child, err = parseExpression(params[3].value, params[3].offset)
+	if err != nil {
+		return nil, err
 	}
 
-	tsdb.Order = append([]string{"rate"}, tsdb.Order...)
-
-	return params[3], nil
+	return node, nil
 }
 
 func writeRate(exp string, rate bool, rateOptions Rate) string {
@@ -59,3 +100,47 @@ func writeRate(exp string, rate bool, rateOptions Rate) string {
 	}
 	return exp
 }
+
+// RateSeries - returns the per second rate of change between consecutive points like OpenTSDB, the timestamps
+// are in milliseconds so sub second intervals are normalised too and points with the same timestamp are skipped.
+// For counters a value lower than the previous one is a rollover, the delta is taken up to CounterMax (the max
+// int64 when not set) and when ResetValue is set a rollover rate above it is a counter reset, reported as zero.
+// A counter value above CounterMax is an error.
+func RateSeries(points DataPoints, opts Rate) (DataPoints, error) {
+
+	rates := DataPoints{}
+
+	counterMax := float64(math.MaxInt64)
+	if opts.CounterMax != nil {
+		counterMax = float64(*opts.CounterMax)
+	}
+
+	for i := 1; i < len(points); i++ {
+
+		prev, cur := points[i-1], points[i]
+
+		elapsed := float64(cur.Timestamp-prev.Timestamp) / 1000
+		if elapsed <= 0 {
+			continue
+		}
+
+		if !opts.Counter || cur.Value >= prev.Value {
+			rates = append(rates, DataPoint{Timestamp: cur.Timestamp, Value: (cur.Value - prev.Value) / elapsed})
+			continue
+		}
+
+		if prev.Value > counterMax {
+			return nil, fmt.Errorf("counter value %v at %d is greater than the counter max %v", prev.Value, prev.Timestamp, counterMax)
+		}
+
+		rate := (counterMax - prev.Value + cur.Value) / elapsed
+
+		if opts.ResetValue > 0 && rate > float64(opts.ResetValue) {
+			rate = 0
+		}
+
+		rates = append(rates, DataPoint{Timestamp: cur.Timestamp, Value: rate})
+	}
+
+	return rates, nil
+}
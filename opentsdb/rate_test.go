@@ -0,0 +1,124 @@
+package opentsdb
+
+import (
+	"math"
+	"testing"
+)
+
+func int64Pointer(v int64) *int64 {
+	return &v
+}
+
+// TestRateSeries - the rate conformance cases taken from the OpenTSDB rate semantics
+func TestRateSeries(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		options  Rate
+		points   DataPoints
+		expected DataPoints
+		err      bool
+	}{
+		{
+			name:     "empty series",
+			points:   DataPoints{},
+			expected: DataPoints{},
+		},
+		{
+			name:     "single point has no rate",
+			points:   DataPoints{{1000, 10}},
+			expected: DataPoints{},
+		},
+		{
+			name:     "per second normalisation",
+			points:   DataPoints{{0, 0}, {10000, 50}, {70000, 110}},
+			expected: DataPoints{{10000, 5}, {70000, 1}},
+		},
+		{
+			name:     "millisecond resolution",
+			points:   DataPoints{{1000, 1}, {1250, 2}, {1500, 2}},
+			expected: DataPoints{{1250, 4}, {1500, 0}},
+		},
+		{
+			name:     "points with the same timestamp are skipped",
+			points:   DataPoints{{1000, 1}, {1000, 5}, {2000, 6}},
+			expected: DataPoints{{2000, 1}},
+		},
+		{
+			name:     "gauges can have negative rates",
+			points:   DataPoints{{0, 10}, {1000, 4}},
+			expected: DataPoints{{1000, -6}},
+		},
+		{
+			name:     "counter rollover at the max int64",
+			options:  Rate{Counter: true},
+			points:   DataPoints{{0, math.MaxInt64}, {1000, 10}},
+			expected: DataPoints{{1000, 10}},
+		},
+		{
+			name:     "counter rollover at the counter max",
+			options:  Rate{Counter: true, CounterMax: int64Pointer(100)},
+			points:   DataPoints{{0, 90}, {2000, 10}, {4000, 30}},
+			expected: DataPoints{{2000, 10}, {4000, 10}},
+		},
+		{
+			name:     "counter reset above the reset value",
+			options:  Rate{Counter: true, CounterMax: int64Pointer(1000), ResetValue: 100},
+			points:   DataPoints{{0, 500}, {1000, 10}, {2000, 20}},
+			expected: DataPoints{{1000, 0}, {2000, 10}},
+		},
+		{
+			name:     "counter rollover below the reset value",
+			options:  Rate{Counter: true, CounterMax: int64Pointer(1000), ResetValue: 100},
+			points:   DataPoints{{0, 990}, {1000, 10}},
+			expected: DataPoints{{1000, 20}},
+		},
+		{
+			name:     "reset value only applies to rollovers",
+			options:  Rate{Counter: true, ResetValue: 5},
+			points:   DataPoints{{0, 0}, {1000, 100}},
+			expected: DataPoints{{1000, 100}},
+		},
+		{
+			name:     "reset value is ignored for gauges",
+			options:  Rate{ResetValue: 5},
+			points:   DataPoints{{0, 100}, {1000, 0}},
+			expected: DataPoints{{1000, -100}},
+		},
+		{
+			name:    "counter value above the counter max",
+			options: Rate{Counter: true, CounterMax: int64Pointer(100)},
+			points:  DataPoints{{0, 150}, {1000, 10}},
+			err:     true,
+		},
+	}
+
+	for _, c := range cases {
+
+		t.Run(c.name, func(t *testing.T) {
+
+			rates, err := RateSeries(c.points, c.options)
+
+			if c.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(rates) != len(c.expected) {
+				t.Fatalf("expected %v but got %v", c.expected, rates)
+			}
+
+			for i := range rates {
+				if rates[i].Timestamp != c.expected[i].Timestamp || math.Abs(rates[i].Value-c.expected[i].Value) > 1e-9 {
+					t.Fatalf("expected %v but got %v", c.expected, rates)
+				}
+			}
+		})
+	}
+}
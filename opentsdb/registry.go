@@ -0,0 +1,116 @@
+package opentsdb
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+var validAggregatorName = regexp.MustCompile(`^[A-Za-z][0-9A-Za-z_]*$`)
+
+// Aggregator - a function reducing many values into one, used to merge series and to downsample points
+type Aggregator struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+
+	// Reduce - reduces a non empty set of values into one
+	Reduce func(values []float64) float64 `json:"-"`
+
+	// Interpolate - when merging, series without a point at a timestamp contribute with
+	// a value linearly interpolated from their neighbour points, otherwise they are skipped
+	Interpolate bool `json:"interpolate"`
+}
+
+// registry - a set of aggregators kept in registration order
+type registry struct {
+	mutex       sync.RWMutex
+	names       []string
+	aggregators map[string]Aggregator
+}
+
+var (
+	aggregatorRegistry  = newRegistry(builtinAggregators)
+	downsamplerRegistry = newRegistry(builtinAggregators)
+)
+
+func newRegistry(aggregators []Aggregator) *registry {
+
+	r := &registry{
+		aggregators: map[string]Aggregator{},
+	}
+
+	for _, aggr := range aggregators {
+		if err := r.register(aggr); err != nil {
+			panic(err)
+		}
+	}
+
+	return r
+}
+
+func (r *registry) register(aggr Aggregator) error {
+
+	if !validAggregatorName.MatchString(aggr.Name) {
+		return fmt.Errorf("invalid aggregator name %s", aggr.Name)
+	}
+
+	if aggr.Reduce == nil {
+		return errors.New("aggregator reduce function cannot be nil")
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.aggregators[aggr.Name]; ok {
+		return fmt.Errorf("aggregator %s is already registered", aggr.Name)
+	}
+
+	r.names = append(r.names, aggr.Name)
+	r.aggregators[aggr.Name] = aggr
+
+	return nil
+}
+
+func (r *registry) get(name string) (Aggregator, bool) {
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	aggr, ok := r.aggregators[name]
+
+	return aggr, ok
+}
+
+func (r *registry) list() []string {
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	names := make([]string, len(r.names))
+	copy(names, r.names)
+
+	return names
+}
+
+// RegisterAggregator - registers a custom aggregator accepted by the merge function,
+// it should be called at init time
+func RegisterAggregator(aggr Aggregator) error {
+	return aggregatorRegistry.register(aggr)
+}
+
+// RegisterDownsampler - registers a custom downsampler accepted by the downsample function,
+// it should be called at init time
+func RegisterDownsampler(aggr Aggregator) error {
+	return downsamplerRegistry.register(aggr)
+}
+
+// GetAggregator - returns a registered aggregator
+func GetAggregator(name string) (Aggregator, bool) {
+	return aggregatorRegistry.get(name)
+}
+
+// GetDownsampler - returns a registered downsampler
+func GetDownsampler(name string) (Aggregator, bool) {
+	return downsamplerRegistry.get(name)
+}
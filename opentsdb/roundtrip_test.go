@@ -0,0 +1,175 @@
+package opentsdb
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// checkRoundTrip - checks that parsing every expression compiled from a valid query gives back the
+// query relative and the normalized expression, which is what allows storing expressions as keys
+func checkRoundTrip(query Query) error {
+
+	normalized := query.Normalize()
+
+	exps := CompileExpression([]Query{query})
+
+	if len(exps) != len(normalized.Queries) {
+		return fmt.Errorf("compiled %d expressions from %d queries", len(exps), len(normalized.Queries))
+	}
+
+	for i, exp := range exps {
+
+		parsed := Expression{}
+
+		relative, err := ParseExpression(exp, &parsed)
+		if err != nil {
+			return fmt.Errorf("queries[%d]: expression %s does not parse: %s", i, exp, err)
+		}
+
+		if relative != normalized.Relative {
+			return fmt.Errorf("queries[%d]: expression %s parses to relative %s instead of %s", i, exp, relative, normalized.Relative)
+		}
+
+		if !reflect.DeepEqual(parsed, normalized.Queries[i]) {
+			return fmt.Errorf("queries[%d]: expression %s parses to %+v instead of %+v", i, exp, parsed, normalized.Queries[i])
+		}
+
+		if again := CompileExpression([]Query{{Relative: relative, Queries: []Expression{parsed}}}); again[0] != exp {
+			return fmt.Errorf("queries[%d]: expression %s is compiled again as %s", i, exp, again[0])
+		}
+	}
+
+	return nil
+}
+
+// TestRoundTripProperty - checks the round trip of random valid queries generated from fixed seeds
+func TestRoundTripProperty(t *testing.T) {
+
+	for seed := int64(1); seed <= 10; seed++ {
+
+		r := rand.New(rand.NewSource(seed))
+
+		for i := 0; i < 500; i++ {
+
+			query := randomQuery(r)
+
+			if err := query.Validate(); err != nil {
+				t.Fatalf("seed %d: generated the invalid query %+v: %s", seed, query, err)
+			}
+
+			if err := checkRoundTrip(query); err != nil {
+				t.Fatalf("seed %d: query %+v: %s", seed, query, err)
+			}
+		}
+	}
+}
+
+// roundTripValues - the values used to generate random queries
+var (
+	roundTripFields     = []string{"a", "host", "os.cpu", "app-name", "dc_1", "%2F", "x#y;z", "v/1"}
+	roundTripWildcards  = []string{"*", "web*", "*.sp", "a*b*"}
+	roundTripRegexps    = []string{".", "web.", "a\\.b", "x-._%"}
+	roundTripFilterType = []string{"literal_or", "not_literal_or", "wildcard", "regexp", "iliteral_or", "not_iliteral_or", "iwildcard"}
+	roundTripDurations  = []string{"ms", "s", "m", "h", "d", "w", "n", "y"}
+	roundTripTimezones  = []string{"UTC", "America/Sao_Paulo", "Asia/Tokyo"}
+	roundTripFillers    = []string{FillNone, FillNaN, FillNull, FillZero, FillPrevious, FillLinear, "constant:0", "constant:-1.5", "constant:1e3"}
+	roundTripConditions = []string{">=10", "< 5", "10..20", "!isnan", ">=10 && <20 || isnan", "(( >1 ))", "!(<0||>100)", "!=-1"}
+)
+
+// randomQuery - generates a random valid query
+func randomQuery(r *rand.Rand) Query {
+
+	pick := func(values []string) string {
+		return values[r.Intn(len(values))]
+	}
+
+	query := Query{
+		Relative: fmt.Sprintf("%d%s", 1+r.Intn(60), pick(roundTripDurations)),
+	}
+
+	if r.Intn(3) == 0 {
+		query.Timezone = pick(roundTripTimezones)
+	}
+
+	for i := 0; i <= r.Intn(3); i++ {
+
+		exp := Expression{
+			Metric:     pick(roundTripFields),
+			Aggregator: pick(GetAggregators()),
+		}
+
+		if r.Intn(2) == 0 {
+			interval := fmt.Sprintf("%d%s", 1+r.Intn(30), pick(roundTripDurations))
+			if r.Intn(3) == 0 && interval[len(interval)-1] != 's' {
+				interval += calendarSuffix
+			}
+			exp.Downsample = fmt.Sprintf("%s-%s", interval, pick(GetDownsamplers()))
+			if r.Intn(4) > 0 {
+				exp.Downsample = fmt.Sprintf("%s-%s", exp.Downsample, pick(roundTripFillers))
+			}
+			if r.Intn(3) == 0 {
+				exp.Timezone = pick(roundTripTimezones)
+			}
+		}
+
+		if r.Intn(2) == 0 {
+			exp.Rate = true
+			exp.RateOptions.Counter = r.Intn(2) == 0
+			if r.Intn(2) == 0 {
+				counterMax := r.Int63n(1 << 40)
+				exp.RateOptions.CounterMax = &counterMax
+			}
+			exp.RateOptions.ResetValue = r.Int63n(1000)
+		}
+
+		if r.Intn(2) == 0 {
+			exp.FilterValue = pick(roundTripConditions)
+		}
+
+		if r.Intn(3) == 0 {
+			exp.TopN = &TopN{Count: 1 + r.Intn(20), Aggregator: pick(GetAggregators()), Bottom: r.Intn(2) == 0}
+		}
+
+		if r.Intn(4) == 0 {
+			exp.Tags = map[string]string{pick(roundTripFields): pick(append(roundTripFields, roundTripWildcards...))}
+		}
+
+		for j := 0; j < r.Intn(5); j++ {
+			exp.Filters = append(exp.Filters, randomFilter(r, pick))
+		}
+
+		if r.Intn(2) == 0 {
+			exp.Order = exp.defaultOrder()
+			r.Shuffle(len(exp.Order), func(i, j int) { exp.Order[i], exp.Order[j] = exp.Order[j], exp.Order[i] })
+		}
+
+		query.Queries = append(query.Queries, exp)
+	}
+
+	return query
+}
+
+func randomFilter(r *rand.Rand, pick func([]string) string) Filter {
+
+	filter := Filter{
+		Ftype:   pick(roundTripFilterType),
+		Tagk:    pick(roundTripFields),
+		GroupBy: r.Intn(2) == 0,
+	}
+
+	switch filter.Ftype {
+	case "wildcard", "iwildcard":
+		filter.Filter = pick(roundTripWildcards)
+	case "regexp":
+		filter.Filter = pick(roundTripRegexps)
+	default:
+		filter.Filter = pick(roundTripFields)
+		if r.Intn(2) == 0 {
+			filter.Filter += "|" + pick(roundTripFields)
+		}
+	}
+
+	return filter
+}
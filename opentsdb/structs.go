@@ -6,6 +6,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -23,12 +24,14 @@ const (
 type Expression struct {
 	Aggregator  string            `json:"aggregator"`
 	Downsample  string            `json:"downsample,omitempty"`
+	Timezone    string            `json:"timezone,omitempty"`
 	Metric      string            `json:"metric"`
 	Tags        map[string]string `json:"tags"`
 	Rate        bool              `json:"rate,omitempty"`
 	RateOptions Rate              `json:"rateOptions,omitempty"`
 	Order       []string          `json:"order,omitempty"`
 	FilterValue string            `json:"filterValue,omitempty"`
+	TopN        *TopN             `json:"topN,omitempty"`
 	Filters     []Filter          `json:"filters,omitempty"`
 }
 
@@ -37,321 +40,401 @@ type Query struct {
 	Start        int64        `json:"start,omitempty"`
 	End          int64        `json:"end,omitempty"`
 	Relative     string       `json:"relative,omitempty"`
+	Timezone     string       `json:"timezone,omitempty"`
 	Queries      []Expression `json:"queries"`
 	ShowTSUIDs   bool         `json:"showTSUIDs"`
 	MsResolution bool         `json:"msResolution"`
 	EstimateSize bool         `json:"estimateSize"`
 }
 
-// Validate - validates the payload
+// Validate - validates the payload, returning the first violation found as a *ValidationError
 func (query *Query) Validate() error {
 
+	v := validator{}
+
+	query.validate(&v)
+
+	if len(v.errs) == 0 {
+		return nil
+	}
+
+	return v.errs[0]
+}
+
+// ValidateAll - validates the payload, returning every violation found as ValidationErrors
+func (query *Query) ValidateAll() error {
+
+	v := validator{all: true}
+
+	query.validate(&v)
+
+	if len(v.errs) == 0 {
+		return nil
+	}
+
+	return v.errs
+}
+
+func (query *Query) validate(v *validator) {
+
 	if query.Relative != stringsEmpty {
-		if err := query.checkDuration(query.Relative); err != nil {
-			return err
+		if !v.check("relative", query.checkDuration(query.Relative)) {
+			return
 		}
 	}
 
-	if len(query.Queries) == 0 {
-		return errors.New("at least one query should be present")
+	if query.Timezone != stringsEmpty {
+		if !v.check("timezone", query.checkTimezone()) {
+			return
+		}
+	}
+
+	if !v.check("end", query.checkTimeRange()) {
+		return
 	}
 
-	for i, q := range query.Queries {
+	if len(query.Queries) == 0 {
+		v.check("queries", newValidationError(CodeRequired, nil, "at least one query should be present"))
+		return
+	}
 
-		if err := query.checkField("metric", q.Metric); err != nil {
-			return err
+	for i := range query.Queries {
+		if !query.validateExpression(v, i) {
+			return
 		}
+	}
+}
 
-		if err := query.checkAggregator(q.Aggregator); err != nil {
-			return err
-		}
+func (query *Query) validateExpression(v *validator, i int) bool {
 
-		if q.Downsample != stringsEmpty {
+	q := query.Queries[i]
 
-			ds := strings.Split(q.Downsample, "-")
+	path := fmt.Sprintf("queries[%d]", i)
 
-			if len(ds) < 2 {
-				return errors.New("invalid downsample format")
-			}
+	if !v.check(path+".metric", query.checkField("metric", q.Metric)) {
+		return false
+	}
 
-			if err := query.checkDuration(ds[0]); err != nil {
-				return err
-			}
+	if !v.check(path+".aggregator", query.checkAggregator(q.Aggregator)) {
+		return false
+	}
 
-			if err := query.checkDownsampler(ds[1]); err != nil {
-				return err
-			}
+	if q.Downsample != stringsEmpty {
+		if !v.check(path+".downsample", query.checkDownsample(q.Downsample)) {
+			return false
+		}
+	}
 
-			if len(ds) > 2 {
-				if err := query.checkFiller(ds[2]); err != nil {
-					return err
-				}
+	if q.Timezone != stringsEmpty {
+		if _, err := time.LoadLocation(q.Timezone); err != nil {
+			if !v.check(path+".timezone", newValidationError(CodeInvalidTimezone, q.Timezone, "unknown timezone %s", q.Timezone)) {
+				return false
 			}
+		}
+	}
 
+	if q.Rate {
+		if !v.check(path+".rateOptions.counterMax", query.checkRate(q.RateOptions)) {
+			return false
 		}
+	}
 
-		if q.Rate {
-			if err := query.checkRate(q.RateOptions); err != nil {
-				return err
-			}
+	if q.TopN != nil {
+		if !v.check(path+".topN", query.checkTopN(*q.TopN)) {
+			return false
 		}
+	}
 
-		if q.FilterValue != stringsEmpty {
-			q.FilterValue = strings.Replace(q.FilterValue, stringsWhiteSpace, stringsEmpty, -1)
-			query.Queries[i].FilterValue = q.FilterValue
+	if q.FilterValue != stringsEmpty {
+		q.FilterValue = strings.Replace(q.FilterValue, stringsWhiteSpace, stringsEmpty, -1)
+		query.Queries[i].FilterValue = q.FilterValue
 
-			if len(q.FilterValue) < 2 {
-				return fmt.Errorf("invalid filter value %s", q.FilterValue)
-			}
+		if !v.check(path+".filterValue", query.checkFilterValue(q.FilterValue)) {
+			return false
+		}
+	}
 
-			if q.FilterValue[:2] == ">=" || q.FilterValue[:2] == "<=" || q.FilterValue[:2] == "==" || q.FilterValue[:2] == "!=" {
-				_, err := strconv.ParseFloat(q.FilterValue[2:], 64)
-				if err != nil {
-					return err
-				}
-			} else if q.FilterValue[:1] == ">" || q.FilterValue[:1] == "<" {
-				_, err := strconv.ParseFloat(q.FilterValue[1:], 64)
-				if err != nil {
-					return err
-				}
-			} else {
-				return fmt.Errorf("invalid filter value %s", q.FilterValue)
-			}
+	if len(q.Order) == 0 {
+		query.Queries[i].Order = q.defaultOrder()
+	} else if !v.check(path+".order", query.checkOrder(q)) {
+		return false
+	}
+
+	for j, filter := range q.Filters {
+		if !query.checkFilter(v, fmt.Sprintf("%s.filters[%d]", path, j), filter) {
+			return false
 		}
+	}
 
-		if len(q.Order) == 0 {
+	return true
+}
 
-			if q.FilterValue != stringsEmpty {
-				query.Queries[i].Order = append(query.Queries[i].Order, "filterValue")
-			}
+func (query *Query) checkDownsample(downsample string) *ValidationError {
 
-			if q.Downsample != stringsEmpty {
-				query.Queries[i].Order = append(query.Queries[i].Order, "downsample")
-			}
+	ds := strings.SplitN(downsample, "-", 3)
 
-			query.Queries[i].Order = append(query.Queries[i].Order, "aggregation")
+	if len(ds) < 2 {
+		return newValidationError(CodeInvalidDownsample, downsample, "invalid downsample format")
+	}
 
-			if q.Rate {
-				query.Queries[i].Order = append(query.Queries[i].Order, "rate")
-			}
+	if _, err := parseDownsampleInterval(ds[0]); err != nil {
+		return newValidationError(CodeInvalidDuration, ds[0], err.Error())
+	}
 
-		} else {
+	if err := query.checkDownsampler(ds[1]); err != nil {
+		return err
+	}
 
-			orderCheck := make([]string, len(q.Order))
+	if len(ds) > 2 {
+		if err := query.checkFiller(ds[2]); err != nil {
+			return err
+		}
+	}
 
-			copy(orderCheck, q.Order)
+	return nil
+}
 
-			k := 0
-			occur := 0
-			for j, order := range orderCheck {
+func (query *Query) checkFilterValue(filterValue string) *ValidationError {
 
-				if order == "aggregation" {
-					k = j
-					occur++
-				}
+	if _, err := parseValuePredicate(filterValue, 0); err != nil {
+		return newValidationError(CodeInvalidFilterValue, filterValue, "%s at position %d", err.Message, err.Offset)
+	}
 
-			}
+	return nil
+}
 
-			if occur == 0 {
-				return errors.New("aggregation configured but no aggregation found in order array")
-			}
+func (query *Query) checkOrder(q Expression) *ValidationError {
 
-			if occur > 1 {
-				return errors.New("more than one aggregation found in order array")
-			}
+	orderCheck := make([]string, len(q.Order))
 
-			if occur == 1 {
-				orderCheck = append(orderCheck[:k], orderCheck[k+1:]...)
-			}
+	copy(orderCheck, q.Order)
 
-			k = 0
-			occur = 0
-			for j, order := range orderCheck {
+	k := 0
+	occur := 0
+	for j, order := range orderCheck {
 
-				if order == "filterValue" {
-					k = j
-					occur++
-				}
+		if order == "aggregation" {
+			k = j
+			occur++
+		}
 
-			}
+	}
 
-			if q.FilterValue != stringsEmpty && occur == 0 {
-				return errors.New("filterValue configured but no filterValue found in order array")
-			}
+	if occur == 0 {
+		return newValidationError(CodeInvalidOrder, q.Order, "aggregation configured but no aggregation found in order array")
+	}
 
-			if occur > 1 {
-				return errors.New("more than one filterValue found in order array")
-			}
+	if occur > 1 {
+		return newValidationError(CodeInvalidOrder, q.Order, "more than one aggregation found in order array")
+	}
 
-			if occur == 1 {
-				orderCheck = append(orderCheck[:k], orderCheck[k+1:]...)
-			}
+	if occur == 1 {
+		orderCheck = append(orderCheck[:k], orderCheck[k+1:]...)
+	}
 
-			k = 0
-			occur = 0
-			for j, order := range orderCheck {
+	k = 0
+	occur = 0
+	for j, order := range orderCheck {
 
-				if order == "downsample" {
-					k = j
-					occur++
-				}
+		if order == "filterValue" {
+			k = j
+			occur++
+		}
 
-			}
+	}
 
-			if q.Downsample != stringsEmpty && occur == 0 {
-				return errors.New("downsample configured but no downsample found in order array")
-			}
+	if q.FilterValue != stringsEmpty && occur == 0 {
+		return newValidationError(CodeInvalidOrder, q.Order, "filterValue configured but no filterValue found in order array")
+	}
 
-			if occur > 1 {
-				return errors.New("more than one downsample found in order array")
-			}
+	if occur > 1 {
+		return newValidationError(CodeInvalidOrder, q.Order, "more than one filterValue found in order array")
+	}
 
-			if occur == 1 {
-				orderCheck = append(orderCheck[:k], orderCheck[k+1:]...)
-			}
+	if occur == 1 {
+		orderCheck = append(orderCheck[:k], orderCheck[k+1:]...)
+	}
 
-			k = 0
-			occur = 0
-			for j, order := range orderCheck {
+	k = 0
+	occur = 0
+	for j, order := range orderCheck {
 
-				if order == "rate" {
-					k = j
-					occur++
-				}
+		if order == "downsample" {
+			k = j
+			occur++
+		}
 
-			}
+	}
 
-			if q.Rate && occur == 0 {
-				return errors.New("rate configured but no rate found in order array")
-			}
+	if q.Downsample != stringsEmpty && occur == 0 {
+		return newValidationError(CodeInvalidOrder, q.Order, "downsample configured but no downsample found in order array")
+	}
 
-			if occur > 1 {
-				return errors.New("more than one rate found in order array")
-			}
+	if occur > 1 {
+		return newValidationError(CodeInvalidOrder, q.Order, "more than one downsample found in order array")
+	}
 
-			if occur == 1 {
-				orderCheck = append(orderCheck[:k], orderCheck[k+1:]...)
-			}
+	if occur == 1 {
+		orderCheck = append(orderCheck[:k], orderCheck[k+1:]...)
+	}
 
-			if len(orderCheck) != 0 {
-				return fmt.Errorf("invalid operations in order array %v", orderCheck)
-			}
+	k = 0
+	occur = 0
+	for j, order := range orderCheck {
 
+		if order == "rate" {
+			k = j
+			occur++
 		}
 
-		if err := query.checkFilter(q.Filters); err != nil {
-			return err
+	}
+
+	if q.Rate && occur == 0 {
+		return newValidationError(CodeInvalidOrder, q.Order, "rate configured but no rate found in order array")
+	}
+
+	if occur > 1 {
+		return newValidationError(CodeInvalidOrder, q.Order, "more than one rate found in order array")
+	}
+
+	if occur == 1 {
+		orderCheck = append(orderCheck[:k], orderCheck[k+1:]...)
+	}
+
+	k = 0
+	occur = 0
+	for j, order := range orderCheck {
+
+		if order == "topN" {
+			k = j
+			occur++
 		}
 
 	}
 
+	if q.TopN != nil && occur == 0 {
+		return newValidationError(CodeInvalidOrder, q.Order, "topN configured but no topN found in order array")
+	}
+
+	if occur > 1 {
+		return newValidationError(CodeInvalidOrder, q.Order, "more than one topN found in order array")
+	}
+
+	if occur == 1 {
+		orderCheck = append(orderCheck[:k], orderCheck[k+1:]...)
+	}
+
+	if len(orderCheck) != 0 {
+		return newValidationError(CodeInvalidOrder, orderCheck, "invalid operations in order array %v", orderCheck)
+	}
+
 	return nil
 }
 
-func (query *Query) checkRate(opts Rate) error {
+func (query *Query) checkRate(opts Rate) *ValidationError {
 
 	if opts.CounterMax != nil && *opts.CounterMax < 0 {
-		return errors.New("counter max needs to be a positive integer")
+		return newValidationError(CodeInvalidRate, *opts.CounterMax, "counter max needs to be a positive integer")
 	}
 
 	return nil
 }
 
-func (query *Query) checkAggregator(aggr string) error {
+func (query *Query) checkTopN(topN TopN) *ValidationError {
 
-	ok := false
-
-	for _, vAggr := range GetAggregators() {
-		if vAggr == aggr {
-			ok = true
-			break
-		}
+	if topN.Count < 1 {
+		return newValidationError(CodeInvalidTopN, topN.Count, "topN count needs to be a positive integer")
 	}
 
-	if !ok {
-		return errors.New("unknown aggregation value")
+	if _, ok := GetAggregator(topN.Aggregator); !ok {
+		return newValidationError(CodeInvalidTopN, topN.Aggregator, "unknown topN aggregation value")
 	}
 
 	return nil
 }
 
-func (query *Query) checkDownsampler(DSr string) error {
-
-	ok := false
+func (query *Query) checkAggregator(aggr string) *ValidationError {
 
-	for _, vDSr := range GetDownsamplers() {
-		if vDSr == DSr {
-			ok = true
-			break
-		}
-	}
-
-	if !ok {
-		return errors.New("invalid downsample")
+	if _, ok := GetAggregator(aggr); !ok {
+		return newValidationError(CodeUnknownAggregator, aggr, "unknown aggregation value")
 	}
 
 	return nil
 }
 
-func (query *Query) checkFiller(DSf string) error {
-
-	ok := false
+func (query *Query) checkDownsampler(DSr string) *ValidationError {
 
-	for _, vDSf := range GetDownsampleFillers() {
-		if vDSf == DSf {
-			ok = true
-			break
-		}
+	if _, ok := GetDownsampler(DSr); !ok {
+		return newValidationError(CodeInvalidDownsample, DSr, "invalid downsample")
 	}
 
-	if !ok {
-		return errors.New("invalid fill value")
+	return nil
+}
+
+func (query *Query) checkFiller(DSf string) *ValidationError {
+
+	if _, err := parseFillPolicy(DSf); err != nil {
+		return newValidationError(CodeInvalidFill, DSf, err.Error())
 	}
 
 	return nil
 }
 
-func (query *Query) checkFilter(filters []Filter) error {
+// ValidateFilter - validates a single tag filter with the same rules used for the query filters,
+// returning the violation found as a *ValidationError
+func ValidateFilter(filter Filter) error {
 
-	vFilters := GetFilters()
+	v := validator{}
 
-	for _, filter := range filters {
+	if (&Query{}).checkFilter(&v, "filter", filter) {
+		return nil
+	}
 
-		ok := false
+	return v.errs[0]
+}
 
-		ft := filter.Ftype
+func (query *Query) checkFilter(v *validator, path string, filter Filter) bool {
 
-		if ft == "iliteral_or" {
-			ft = "literal_or"
-		} else if ft == "not_iliteral_or" {
-			ft = "not_literal_or"
-		} else if ft == "iwildcard" {
-			ft = "wildcard"
-		}
+	ok := false
 
-		for _, vFilter := range vFilters {
-			if ft == vFilter {
-				ok = true
-				break
-			}
-		}
-		if !ok {
-			return fmt.Errorf("invalid filter type %s", filter.Ftype)
-		}
+	ft := filter.Ftype
 
-		if err := query.checkField("tagk", filter.Tagk); err != nil {
-			return err
-		}
+	if ft == "iliteral_or" {
+		ft = "literal_or"
+	} else if ft == "not_iliteral_or" {
+		ft = "not_literal_or"
+	} else if ft == "iwildcard" {
+		ft = "wildcard"
+	}
 
-		if err := query.checkFilterField("filter", ft, filter.Filter); err != nil {
-			return err
+	for _, vFilter := range GetFilters() {
+		if ft == vFilter {
+			ok = true
+			break
 		}
 	}
+	if !ok {
+		return v.check(path+".type", newValidationError(CodeInvalidFilter, filter.Ftype, "invalid filter type %s", filter.Ftype))
+	}
+
+	if !v.check(path+".tagk", query.checkField("tagk", filter.Tagk)) {
+		return false
+	}
+
+	return v.check(path+".filter", query.checkFilterField("filter", ft, filter.Filter))
+}
+
+func (query *Query) checkDuration(s string) *ValidationError {
+
+	if err := validateDuration(s); err != nil {
+		return newValidationError(CodeInvalidDuration, s, err.Error())
+	}
 
 	return nil
 }
 
-func (query *Query) checkDuration(s string) error {
+// validateDuration - validates a duration like 500ms, 1h or 2w
+func validateDuration(s string) error {
 
 	if len(s) < 2 {
 		return errors.New("invalid time interval")
@@ -385,16 +468,16 @@ func (query *Query) checkDuration(s string) error {
 	return nil
 }
 
-func (query *Query) checkField(n, f string) error {
+func (query *Query) checkField(n, f string) *ValidationError {
 
 	if !validFieldRegexp.MatchString(f) {
-		return fmt.Errorf("Invalid characters in field %s: %s", n, f)
+		return newValidationError(CodeInvalidCharacters, f, "Invalid characters in field %s: %s", n, f)
 	}
 
 	return nil
 }
 
-func (query *Query) checkFilterField(n, tf, f string) error {
+func (query *Query) checkFilterField(n, tf, f string) *ValidationError {
 
 	match := false
 
@@ -408,7 +491,7 @@ func (query *Query) checkFilterField(n, tf, f string) error {
 	}
 
 	if !match {
-		return fmt.Errorf("Invalid characters in field %s: %s", n, f)
+		return newValidationError(CodeInvalidCharacters, f, "Invalid characters in field %s: %s", n, f)
 	}
 
 	return nil
@@ -0,0 +1,256 @@
+package opentsdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	timeSpecNow    string = "now"
+	timeSpecAgo    string = "-ago"
+	maxEpochSecond int64  = 9999999999
+)
+
+// timeSpecLayouts - the absolute date formats accepted by OpenTSDB
+var timeSpecLayouts = []string{
+	"2006/01/02-15:04:05",
+	"2006/01/02 15:04:05",
+	"2006/01/02-15:04",
+	"2006/01/02 15:04",
+	"2006/01/02",
+}
+
+// ParseTimeSpec - resolves an OpenTSDB time specification to an epoch in milliseconds, the specification can be
+// an epoch with 10 digits in seconds or 13 digits in milliseconds, an absolute date like 2016/01/02-12:00:00
+// in the location, a relative time like 1h-ago or now
+func ParseTimeSpec(spec string, loc *time.Location, now time.Time) (int64, error) {
+
+	spec = strings.TrimSpace(spec)
+
+	if spec == stringsEmpty {
+		return 0, errors.New("empty time specification")
+	}
+
+	if spec == timeSpecNow {
+		return toMillis(now), nil
+	}
+
+	if strings.HasSuffix(spec, timeSpecAgo) {
+
+		duration := spec[:len(spec)-len(timeSpecAgo)]
+
+		if err := (&Query{}).checkDuration(duration); err != nil {
+			return 0, fmt.Errorf("invalid relative time %s: %s", spec, err.Message)
+		}
+
+		start, err := GetRelativeStart(now, duration)
+		if err != nil {
+			return 0, err
+		}
+
+		return toMillis(start), nil
+	}
+
+	if isDigits(spec) {
+
+		epoch, err := parseEpoch(spec)
+		if err != nil {
+			return 0, err
+		}
+
+		return epochMillis(epoch), nil
+	}
+
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	for _, layout := range timeSpecLayouts {
+		if t, err := time.ParseInLocation(layout, spec, loc); err == nil {
+			return toMillis(t), nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid time specification %s", spec)
+}
+
+// UnmarshalJSON - decodes the query accepting start and end as epochs or as OpenTSDB time specifications,
+// the epochs are kept as sent, in seconds or milliseconds, and the other specifications are resolved to
+// epochs in milliseconds using the query timezone
+func (query *Query) UnmarshalJSON(data []byte) error {
+
+	type queryAlias Query
+
+	aux := struct {
+		*queryAlias
+		Start json.RawMessage `json:"start,omitempty"`
+		End   json.RawMessage `json:"end,omitempty"`
+	}{
+		queryAlias: (*queryAlias)(query),
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	loc, err := query.location()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	if query.Start, err = parseTimeSpecJSON(aux.Start, loc, now); err != nil {
+		return fmt.Errorf("invalid start: %s", err)
+	}
+
+	if query.End, err = parseTimeSpecJSON(aux.End, loc, now); err != nil {
+		return fmt.Errorf("invalid end: %s", err)
+	}
+
+	return nil
+}
+
+// TimeRange - returns the query start and end as epochs in milliseconds, the end defaults to now and
+// the start is calculated from the relative duration when not set. Start and end epochs with up to 10 digits
+// are in seconds and the ones with 13 digits in milliseconds
+func (query *Query) TimeRange(now time.Time) (start, end int64, err error) {
+
+	end = toMillis(now)
+
+	if query.End != 0 {
+		end = epochMillis(query.End)
+	}
+
+	if query.Start != 0 {
+		return epochMillis(query.Start), end, nil
+	}
+
+	if query.Relative == stringsEmpty {
+		return 0, 0, errors.New("start or relative is required")
+	}
+
+	if err := query.checkDuration(query.Relative); err != nil {
+		return 0, 0, err
+	}
+
+	startTime, err := GetRelativeStart(time.Unix(0, end*int64(time.Millisecond)), query.Relative)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return toMillis(startTime), end, nil
+}
+
+// location - returns the query timezone location, UTC when not set
+func (query *Query) location() (*time.Location, error) {
+
+	if query.Timezone == stringsEmpty {
+		return time.UTC, nil
+	}
+
+	return time.LoadLocation(query.Timezone)
+}
+
+func (query *Query) checkTimezone() *ValidationError {
+
+	if _, err := query.location(); err != nil {
+		return newValidationError(CodeInvalidTimezone, query.Timezone, "unknown timezone %s", query.Timezone)
+	}
+
+	return nil
+}
+
+func (query *Query) checkTimeRange() *ValidationError {
+
+	if query.Start == 0 {
+		return nil
+	}
+
+	start, end, err := query.TimeRange(time.Now())
+	if err != nil {
+		return newValidationError(CodeInvalidTimeRange, query.Start, err.Error())
+	}
+
+	if start >= end {
+		return newValidationError(CodeInvalidTimeRange, query.End, "start must be before end")
+	}
+
+	return nil
+}
+
+// parseTimeSpecJSON - resolves a start or end JSON value to an epoch, which is kept as sent when the value is
+// an epoch, or zero when not set
+func parseTimeSpecJSON(raw json.RawMessage, loc *time.Location, now time.Time) (int64, error) {
+
+	raw = bytes.TrimSpace(raw)
+
+	if len(raw) == 0 || string(raw) == "null" {
+		return 0, nil
+	}
+
+	if raw[0] != '"' {
+		return parseEpoch(string(raw))
+	}
+
+	var spec string
+
+	if err := json.Unmarshal(raw, &spec); err != nil {
+		return 0, err
+	}
+
+	if isDigits(spec) {
+		return parseEpoch(spec)
+	}
+
+	return ParseTimeSpec(spec, loc, now)
+}
+
+// parseEpoch - parses an epoch with up to 10 digits in seconds or 13 digits in milliseconds, as it is written
+func parseEpoch(s string) (int64, error) {
+
+	epoch, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(s) > 10 && len(s) != 13 {
+		return 0, fmt.Errorf("invalid epoch %s, it must have 10 digits in seconds or 13 digits in milliseconds", s)
+	}
+
+	return epoch, nil
+}
+
+// epochMillis - converts an epoch in seconds to milliseconds, epochs with more than 10 digits are already in milliseconds
+func epochMillis(epoch int64) int64 {
+
+	if epoch <= maxEpochSecond {
+		return epoch * 1000
+	}
+
+	return epoch
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func isDigits(s string) bool {
+
+	if s == stringsEmpty {
+		return false
+	}
+
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
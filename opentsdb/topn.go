@@ -0,0 +1,169 @@
+package opentsdb
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// TopN - keeps the Count series ranked highest, or lowest when Bottom is set, by the aggregator of their values
+type TopN struct {
+	Count      int    `json:"count"`
+	Aggregator string `json:"aggregator"`
+	Bottom     bool   `json:"bottom,omitempty"`
+}
+
+// TopNNode - the topN(count,aggregator,expression) and bottomN(count,aggregator,expression) functions
+type TopNNode struct {
+	position
+
+	Options TopN
+	Child   Node
+}
+
+// Function - returns the expression function name of the node
+func (n *TopNNode) Function() string {
+	if n.Options.Bottom {
+		return "bottomN"
+	}
+	return "topN"
+}
+
+// Children - returns the nodes nested inside this node
+func (n *TopNNode) Children() []Node {
+	return []Node{n.Child}
+}
+
+// String - writes the node and its children as an expression
+func (n *TopNNode) String() string {
+	return writeTopN(n.Child.String(), &n.Options)
+}
+
+func (n *TopNNode) lower(tsdb *Expression) (string, error) {
+
+	relative, err := n.Child.lower(tsdb)
+	if err != nil {
+		return relative, err
+	}
+
+	if hasOperation(tsdb, "topN") {
+		return stringsEmpty, newDuplicateError(n, n.position, tsdb, "found more than one 'topN' or 'bottomN' function")
+	}
+
+	options := n.Options
+	tsdb.TopN = &options
+
+	tsdb.Order = append(tsdb.Order, "topN")
+
+	return relative, nil
+}
+
+func parseTopN(exp string, offset int, bottom bool) (Node, error) {
+
+	function := "topN"
+	if bottom {
+		function = "bottomN"
+	}
+
+	params, err := parseArgs(exp, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkParams(function, params, 3); err != nil {
+		return nil, err
+	}
+
+	count, err := strconv.Atoi(params[0].atom())
+	if err != nil || count < 1 {
+		return nil, newParseError(params[0].offset, params[0].value, []string{"<positive integer>"}, "invalid %s count", function)
+	}
+
+	if err := checkParamValue(params[1], GetAggregators(), "aggregator"); err != nil {
+		return nil, err
+	}
+
+	child, err := parseExpression(params[2].value, params[2].offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TopNNode{
+		Options: TopN{
+			Count:      count,
+			Aggregator: params[1].atom(),
+			Bottom:     bottom,
+		},
+		Child: child,
+	}, nil
+}
+
+func writeTopN(exp string, topN *TopN) string {
+	if topN != nil {
+		function := "topN"
+		if topN.Bottom {
+			function = "bottomN"
+		}
+		exp = fmt.Sprintf("%s(%d,%s,%s)", function, topN.Count, topN.Aggregator, exp)
+	}
+	return exp
+}
+
+// topNSeries - keeps the series ranked first by the aggregator of their values, series without
+// points are ranked last and ties keep the order of the tags
+func topNSeries(series []Series, topN TopN) ([]Series, error) {
+
+	aggr, ok := GetAggregator(topN.Aggregator)
+	if !ok {
+		return nil, fmt.Errorf("unknown aggregation value %s", topN.Aggregator)
+	}
+
+	type ranked struct {
+		series Series
+		key    string
+		value  float64
+		empty  bool
+	}
+
+	ranking := make([]ranked, len(series))
+
+	for i, s := range series {
+
+		values := make([]float64, 0, len(s.Points))
+		for _, p := range s.Points {
+			if !math.IsNaN(p.Value) {
+				values = append(values, p.Value)
+			}
+		}
+
+		ranking[i] = ranked{series: s, key: tagsKey(s.Tags), empty: len(values) == 0}
+
+		if !ranking[i].empty {
+			ranking[i].value = aggr.Reduce(values)
+		}
+	}
+
+	sort.SliceStable(ranking, func(i, j int) bool {
+		a, b := ranking[i], ranking[j]
+		if a.empty != b.empty {
+			return b.empty
+		}
+		if a.value != b.value {
+			return (a.value > b.value) != topN.Bottom
+		}
+		return a.key < b.key
+	})
+
+	if len(ranking) > topN.Count {
+		ranking = ranking[:topN.Count]
+	}
+
+	selected := make([]Series, len(ranking))
+
+	for i, r := range ranking {
+		selected[i] = r.series
+	}
+
+	return selected, nil
+}
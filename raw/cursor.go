@@ -0,0 +1,90 @@
+package raw
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+)
+
+//
+// The raw query pagination cursor.
+//
+
+// Cursor - the position of the last point returned by a paged raw query, series are paged ordered
+// by their key and the points of each series by their timestamp
+type Cursor struct {
+	Key       string `json:"key"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// NewCursor - creates a cursor pointing to the point of the series
+func NewCursor(metadata Metadata, timestamp int64) *Cursor {
+	return &Cursor{
+		Key:       SeriesKey(metadata),
+		Timestamp: timestamp,
+	}
+}
+
+// ParseCursor - parses a continuation token created by the cursor String function
+func ParseCursor(token string) (*Cursor, error) {
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+
+	cursor := &Cursor{}
+	if err := decoder.Decode(cursor); err != nil {
+		return nil, err
+	}
+
+	if cursor.Key == "" {
+		return nil, errors.New("cursor without a series key")
+	}
+
+	return cursor, nil
+}
+
+// String - returns the opaque continuation token of the cursor
+func (c *Cursor) String() string {
+
+	data, _ := json.Marshal(c)
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Skip - returns true if the point was already returned by the previous pages
+func (c *Cursor) Skip(metadata Metadata, timestamp int64) bool {
+
+	key := SeriesKey(metadata)
+
+	return key < c.Key || (key == c.Key && timestamp <= c.Timestamp)
+}
+
+// SeriesKey - returns the key ordering the series in the pages, the metric followed by the sorted tags
+func SeriesKey(metadata Metadata) string {
+
+	keys := make([]string, 0, len(metadata.Tags))
+	for k := range metadata.Tags {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	b := strings.Builder{}
+	b.WriteString(metadata.Metric)
+
+	for _, k := range keys {
+		b.WriteByte(',')
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(metadata.Tags[k])
+	}
+
+	return b.String()
+}
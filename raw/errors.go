@@ -0,0 +1,107 @@
+package raw
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+//
+// The raw query parsing errors.
+//
+
+// ParseErrorReason - the reason a raw query field was rejected
+type ParseErrorReason string
+
+const (
+	// ReasonMissing - a mandatory field is missing
+	ReasonMissing ParseErrorReason = "missing"
+
+	// ReasonWrongType - the field value has a wrong JSON type
+	ReasonWrongType ParseErrorReason = "wrong_type"
+
+	// ReasonInvalidValue - the field value has the right JSON type but is not valid
+	ReasonInvalidValue ParseErrorReason = "invalid_value"
+
+	// ReasonMalformed - the JSON is malformed
+	ReasonMalformed ParseErrorReason = "malformed"
+)
+
+// ParseError - an error found while parsing a raw query, with the field and the byte offset of its value,
+// missing fields are reported at the offset of the object expected to have them and malformed documents
+// without a field at the offset of the syntax error
+type ParseError struct {
+	Field   string           `json:"field"`
+	Reason  ParseErrorReason `json:"reason"`
+	Offset  int              `json:"offset"`
+	Message string           `json:"message"`
+	Err     error            `json:"-"`
+}
+
+func newParseError(err error, field string, reason ParseErrorReason, offset int, format string, args ...interface{}) *ParseError {
+	return &ParseError{
+		Field:   field,
+		Reason:  reason,
+		Offset:  offset,
+		Message: fmt.Sprintf(format, args...),
+		Err:     err,
+	}
+}
+
+// Error - returns the error message with the field and its offset
+func (e *ParseError) Error() string {
+
+	if e.Field == "" {
+		return fmt.Sprintf("%s at offset %d", e.Message, e.Offset)
+	}
+
+	return fmt.Sprintf("%s: %s at offset %d", e.Field, e.Message, e.Offset)
+}
+
+// Unwrap - returns the sentinel error of the failure, like ErrUnmarshalling or ErrMissingMandatoryFields
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Is - missing fields also match ErrMissingMandatoryFields
+func (e *ParseError) Is(target error) bool {
+	return target == ErrMissingMandatoryFields && e.Reason == ReasonMissing
+}
+
+// nested - moves the error of a field parsed inside an object or array to the query
+func nested(err error, parent string, offset int) error {
+
+	var pe *ParseError
+
+	if errors.As(err, &pe) {
+		pe.Field = parent + pe.Field
+		pe.Offset += offset
+	}
+
+	return err
+}
+
+// checkSyntax - checks the whole document before looking for fields, since the lookups of a truncated
+// or malformed document report its fields as missing
+func checkSyntax(data []byte) error {
+
+	var syntaxErr *json.SyntaxError
+
+	if err := json.Unmarshal(data, &json.RawMessage{}); errors.As(err, &syntaxErr) {
+		return newParseError(ErrUnmarshalling, "", ReasonMalformed, int(syntaxErr.Offset), "malformed json: %s", err)
+	}
+
+	return nil
+}
+
+// syntaxErrorOffset - returns the offset of the first syntax error of the data
+func syntaxErrorOffset(data []byte) int {
+
+	var syntaxErr *json.SyntaxError
+
+	if errors.As(json.Unmarshal(data, &json.RawMessage{}), &syntaxErr) {
+		return int(syntaxErr.Offset)
+	}
+
+	return 0
+}
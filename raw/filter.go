@@ -0,0 +1,119 @@
+package raw
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/buger/jsonparser"
+	"github.com/uol/mycenae-shared/opentsdb"
+)
+
+//
+// The raw query tag filters.
+//
+
+// parseFilters - parses the optional tag filters, validated with the opentsdb filter rules,
+// the ksid can only be matched exactly by the tags
+func parseFilters(data []byte) ([]opentsdb.Filter, error) {
+
+	value, dataType, offset, err := lookup(data, rawDataQueryFiltersParam)
+	if err != nil || offset == -1 {
+		return nil, err
+	}
+
+	if err = expectType(rawDataQueryFiltersParam, dataType, jsonparser.Array, offset); err != nil {
+		return nil, err
+	}
+
+	var filters []opentsdb.Filter
+	var parseErr error
+
+	_, err = jsonparser.ArrayEach(value, func(element []byte, dataType jsonparser.ValueType, start int, err error) {
+
+		if parseErr != nil {
+			return
+		}
+
+		index := fmt.Sprintf("%s[%d]", rawDataQueryFiltersParam, len(filters))
+
+		// the offsets of strings are given at their closing quote
+		if dataType == jsonparser.String {
+			start -= 2
+		}
+
+		if parseErr = expectType(index, dataType, jsonparser.Object, offset+start); parseErr != nil {
+			return
+		}
+
+		filter, err := parseFilter(element)
+		if err != nil {
+			parseErr = nested(err, index+".", offset+start)
+			return
+		}
+
+		filters = append(filters, filter)
+	})
+
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	if err != nil {
+		return nil, newParseError(ErrUnmarshalling, rawDataQueryFiltersParam, ReasonMalformed, offset+syntaxErrorOffset(value), "malformed json: %s", err)
+	}
+
+	return filters, nil
+}
+
+func parseFilter(data []byte) (opentsdb.Filter, error) {
+
+	var err error
+	var offsets [3]int
+
+	filter := opentsdb.Filter{}
+
+	if filter.Ftype, offsets[0], err = getString(data, rawDataQueryFilterType, true); err != nil {
+		return filter, err
+	}
+
+	if filter.Tagk, offsets[1], err = getString(data, rawDataQueryFilterTagk, true); err != nil {
+		return filter, err
+	}
+
+	if filter.Filter, offsets[2], err = getString(data, rawDataQueryFilterValue, true); err != nil {
+		return filter, err
+	}
+
+	if filter.Tagk == rawDataQueryKSID {
+		return filter, newParseError(ErrInvalidFilter, rawDataQueryFilterTagk, ReasonInvalidValue, offsets[1], "the %s can only be set in the tags", rawDataQueryKSID)
+	}
+
+	if err = opentsdb.ValidateFilter(filter); err != nil {
+
+		var ve *opentsdb.ValidationError
+		if !errors.As(err, &ve) {
+			return filter, err
+		}
+
+		// the validation paths are like filter.tagk
+		field := ve.Path[strings.LastIndex(ve.Path, ".")+1:]
+		offset := offsets[0]
+
+		switch field {
+		case rawDataQueryFilterTagk:
+			offset = offsets[1]
+		case rawDataQueryFilterValue:
+			offset = offsets[2]
+		}
+
+		return filter, newParseError(ErrInvalidFilter, field, ReasonInvalidValue, offset, "%s", ve.Message)
+	}
+
+	return filter, nil
+}
+
+// TagMatchers - compiles the query tag filters, the exact tags are not included
+func (dq *Query) TagMatchers() (opentsdb.TagMatchers, error) {
+	return opentsdb.CompileFilters(dq.Filters)
+}
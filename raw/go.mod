@@ -2,4 +2,9 @@ module github.com/uol/mycenae-shared/raw
 
 go 1.14
 
-require github.com/buger/jsonparser v1.0.0
+require (
+	github.com/buger/jsonparser v1.0.0
+	github.com/uol/mycenae-shared/opentsdb v0.0.0
+)
+
+replace github.com/uol/mycenae-shared/opentsdb => ../opentsdb
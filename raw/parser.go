@@ -1,6 +1,8 @@
 package raw
 
 import (
+	"time"
+
 	"github.com/buger/jsonparser"
 )
 
@@ -9,54 +11,232 @@ import (
 // author: rnojiri
 //
 
-// Parse - parses the bytes tol JSON
+// Parse - parses the bytes tol JSON, the failures are returned as *ParseError
 func (dq *Query) Parse(data []byte) error {
 
 	var err error
+	var offset, sinceOffset, untilOffset int
+
+	if err = checkSyntax(data); err != nil {
+		return err
+	}
 
-	if dq.Type, err = jsonparser.GetString(data, rawDataQueryTypeParam); err != nil {
-		return ErrUnmarshalling
+	if dq.Type, offset, err = getString(data, rawDataQueryTypeParam, true); err != nil {
+		return err
 	}
 
 	if dq.Type != rawDataQueryNumberType && dq.Type != rawDataQueryTextType {
-		return ErrMissingMandatoryFields
+		return newParseError(ErrMissingMandatoryFields, rawDataQueryTypeParam, ReasonInvalidValue, offset, "unknown type %s, expected %s or %s", dq.Type, rawDataQueryNumberType, rawDataQueryTextType)
 	}
 
-	if dq.Metric, err = jsonparser.GetString(data, rawDataQueryMetricParam); err != nil {
-		return ErrUnmarshalling
+	if dq.Metric, _, err = getString(data, rawDataQueryMetricParam, true); err != nil {
+		return err
 	}
 
-	if dq.Since, err = jsonparser.GetString(data, rawDataQuerySinceParam); err != nil {
-		return ErrUnmarshalling
+	if dq.Since, sinceOffset, err = getString(data, rawDataQuerySinceParam, true); err != nil {
+		return err
 	}
 
-	if dq.Until, err = jsonparser.GetString(data, rawDataQueryUntilParam); err != nil && err != jsonparser.KeyPathNotFoundError {
-		return ErrUnmarshalling
+	if dq.Until, untilOffset, err = getString(data, rawDataQueryUntilParam, false); err != nil {
+		return err
 	}
 
-	if dq.EstimateSize, err = jsonparser.GetBoolean(data, rawDataQueryEstimateSize); err != nil && err != jsonparser.KeyPathNotFoundError {
-		return ErrUnmarshalling
+	now := time.Now()
+
+	if _, err = dq.ResolveSince(now); err != nil {
+		return newParseError(ErrInvalidTimeRange, rawDataQuerySinceParam, ReasonInvalidValue, sinceOffset, "%s", err)
 	}
 
-	dq.Tags = map[string]string{}
-	err = jsonparser.ObjectEach(data, func(key, value []byte, dataType jsonparser.ValueType, offset int) error {
+	if _, err = dq.ResolveUntil(now); err != nil {
+		return newParseError(ErrInvalidTimeRange, rawDataQueryUntilParam, ReasonInvalidValue, untilOffset, "%s", err)
+	}
 
-		tagKey, err := jsonparser.ParseString(key)
-		if err != nil {
-			return ErrUnmarshalling
+	if _, _, err = dq.TimeRange(now); err != nil {
+		return newParseError(ErrInvalidTimeRange, rawDataQuerySinceParam, ReasonInvalidValue, sinceOffset, "%s", err)
+	}
+
+	if dq.EstimateSize, err = getBoolean(data, rawDataQueryEstimateSize); err != nil {
+		return err
+	}
+
+	limit, offset, err := getInt(data, rawDataQueryLimitParam)
+	if err != nil {
+		return err
+	}
+
+	if offset != -1 && limit < 1 {
+		return newParseError(ErrInvalidLimit, rawDataQueryLimitParam, ReasonInvalidValue, offset, "%s", ErrInvalidLimit)
+	}
+
+	dq.Limit = int(limit)
+
+	if dq.Cursor, offset, err = getString(data, rawDataQueryCursorParam, false); err != nil {
+		return err
+	}
+
+	if dq.Cursor != "" {
+		if _, err = ParseCursor(dq.Cursor); err != nil {
+			return newParseError(ErrInvalidCursor, rawDataQueryCursorParam, ReasonInvalidValue, offset, "%s: %s", ErrInvalidCursor, err)
 		}
+	}
 
-		if dq.Tags[tagKey], err = jsonparser.ParseString(value); err != nil {
-			return ErrUnmarshalling
+	if dq.Filters, err = parseFilters(data); err != nil {
+		return err
+	}
+
+	if dq.Tags, err = parseTags(data); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// parseTags - parses the exact tags, the ksid is mandatory
+func parseTags(data []byte) (map[string]string, error) {
+
+	value, dataType, offset, err := lookup(data, rawDataQueryTagsParam)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset == -1 {
+		return nil, newParseError(ErrMissingMandatoryFields, rawDataQueryTagsParam, ReasonMissing, 0, "mandatory field is missing")
+	}
+
+	if err = expectType(rawDataQueryTagsParam, dataType, jsonparser.Object, offset); err != nil {
+		return nil, err
+	}
+
+	tags := map[string]string{}
+
+	err = jsonparser.ObjectEach(value, func(key, tagValue []byte, dataType jsonparser.ValueType, end int) error {
+
+		tagKey := string(key)
+		start := valueStart(tagValue, dataType, end)
+
+		if err := expectType(tagKey, dataType, jsonparser.String, start); err != nil {
+			return err
+		}
+
+		s, err := jsonparser.ParseString(tagValue)
+		if err != nil {
+			return newParseError(ErrUnmarshalling, tagKey, ReasonInvalidValue, start, "%s", err)
 		}
 
+		tags[tagKey] = s
+
 		return nil
+	})
 
-	}, rawDataQueryTagsParam)
+	if _, ok := err.(*ParseError); ok {
+		return nil, nested(err, rawDataQueryTagsParam+".", offset)
+	}
 
-	if _, ok := dq.Tags[rawDataQueryKSID]; !ok {
-		return ErrMissingMandatoryFields
+	if err != nil {
+		return nil, newParseError(ErrUnmarshalling, rawDataQueryTagsParam, ReasonMalformed, offset+syntaxErrorOffset(value), "malformed json: %s", err)
+	}
+
+	if _, ok := tags[rawDataQueryKSID]; !ok {
+		return nil, newParseError(ErrMissingMandatoryFields, rawDataQueryTagsParam+"."+rawDataQueryKSID, ReasonMissing, offset, "mandatory field is missing")
+	}
+
+	return tags, nil
+}
+
+// lookup - returns the field value and the offset where the value starts, the offset is -1 when the field is missing
+func lookup(data []byte, field string) ([]byte, jsonparser.ValueType, int, error) {
+
+	value, dataType, end, err := jsonparser.Get(data, field)
+	if err == jsonparser.KeyPathNotFoundError {
+		return nil, jsonparser.NotExist, -1, nil
+	}
+
+	if err != nil {
+		return nil, dataType, -1, newParseError(ErrUnmarshalling, field, ReasonMalformed, syntaxErrorOffset(data), "malformed json: %s", err)
+	}
+
+	return value, dataType, valueStart(value, dataType, end), nil
+}
+
+// valueStart - returns the offset where a value ending at the offset starts, including the string quotes
+func valueStart(value []byte, dataType jsonparser.ValueType, end int) int {
+
+	if dataType == jsonparser.String {
+		return end - len(value) - 2
+	}
+
+	return end - len(value)
+}
+
+func expectType(field string, dataType, expected jsonparser.ValueType, offset int) error {
+
+	if dataType != expected {
+		return newParseError(ErrUnmarshalling, field, ReasonWrongType, offset, "expected %s but found %s", expected, dataType)
 	}
 
 	return nil
 }
+
+func getString(data []byte, field string, mandatory bool) (string, int, error) {
+
+	value, dataType, offset, err := lookup(data, field)
+	if err != nil {
+		return "", offset, err
+	}
+
+	if offset == -1 {
+		if mandatory {
+			return "", offset, newParseError(ErrUnmarshalling, field, ReasonMissing, 0, "mandatory field is missing")
+		}
+		return "", offset, nil
+	}
+
+	if err = expectType(field, dataType, jsonparser.String, offset); err != nil {
+		return "", offset, err
+	}
+
+	s, err := jsonparser.ParseString(value)
+	if err != nil {
+		return "", offset, newParseError(ErrUnmarshalling, field, ReasonInvalidValue, offset, "%s", err)
+	}
+
+	return s, offset, nil
+}
+
+func getBoolean(data []byte, field string) (bool, error) {
+
+	value, dataType, offset, err := lookup(data, field)
+	if err != nil || offset == -1 {
+		return false, err
+	}
+
+	if err = expectType(field, dataType, jsonparser.Boolean, offset); err != nil {
+		return false, err
+	}
+
+	b, err := jsonparser.ParseBoolean(value)
+	if err != nil {
+		return false, newParseError(ErrUnmarshalling, field, ReasonInvalidValue, offset, "%s", err)
+	}
+
+	return b, nil
+}
+
+func getInt(data []byte, field string) (int64, int, error) {
+
+	value, dataType, offset, err := lookup(data, field)
+	if err != nil || offset == -1 {
+		return 0, offset, err
+	}
+
+	if err = expectType(field, dataType, jsonparser.Number, offset); err != nil {
+		return 0, offset, err
+	}
+
+	i, err := jsonparser.ParseInt(value)
+	if err != nil {
+		return 0, offset, newParseError(ErrUnmarshalling, field, ReasonInvalidValue, offset, "%s is not an integer", value)
+	}
+
+	return i, offset, nil
+}
@@ -0,0 +1,429 @@
+package raw
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+//
+// The raw query results streaming encoder and decoder.
+//
+
+const (
+	rawResultsParam string = "results"
+	rawTotalParam   string = "total"
+	rawNextParam    string = "next"
+)
+
+var (
+	// ErrEncoderClosed - the encoder was already closed
+	ErrEncoderClosed error = errors.New("results encoder is closed")
+
+	// ErrSeriesNotStarted - a point was written without starting a series
+	ErrSeriesNotStarted error = errors.New("no series started to write points")
+
+	// ErrSeriesAlreadyStarted - a series was started before ending the previous one
+	ErrSeriesAlreadyStarted error = errors.New("the previous series was not ended")
+)
+
+// ResultsEncoder - writes the raw query results as {"results":[...],"total":N} while they are produced,
+// without keeping them in memory, the total is the number of series written
+type ResultsEncoder struct {
+	w       io.Writer
+	buffer  []byte
+	total   int
+	next    string
+	points  int
+	started bool
+	opened  bool
+	closed  bool
+}
+
+// NewResultsEncoder - creates a results encoder writing to w
+func NewResultsEncoder(w io.Writer) *ResultsEncoder {
+	return &ResultsEncoder{
+		w:      w,
+		buffer: make([]byte, 0, 512),
+	}
+}
+
+// StartSeries - starts a series with its metadata, its points can then be written one by one
+func (e *ResultsEncoder) StartSeries(metadata Metadata) error {
+
+	if e.closed {
+		return ErrEncoderClosed
+	}
+
+	if e.started {
+		return ErrSeriesAlreadyStarted
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	e.buffer = e.buffer[:0]
+
+	if !e.opened {
+		e.buffer = append(e.buffer, `{"results":[`...)
+		e.opened = true
+	} else if e.total > 0 {
+		e.buffer = append(e.buffer, ',')
+	}
+
+	e.buffer = append(e.buffer, `{"metadata":`...)
+	e.buffer = append(e.buffer, data...)
+	e.buffer = append(e.buffer, `,"points":[`...)
+
+	e.started = true
+	e.points = 0
+
+	return e.flush()
+}
+
+// WriteNumberPoint - writes a number point of the started series
+func (e *ResultsEncoder) WriteNumberPoint(point NumberPoint) error {
+
+	if math.IsNaN(point.Value) || math.IsInf(point.Value, 0) {
+		return fmt.Errorf("unsupported point value %v at %d", point.Value, point.Timestamp)
+	}
+
+	if err := e.startPoint(); err != nil {
+		return err
+	}
+
+	e.buffer = append(e.buffer, `{"timestamp":`...)
+	e.buffer = strconv.AppendInt(e.buffer, point.Timestamp, 10)
+	e.buffer = append(e.buffer, `,"value":`...)
+	e.buffer = appendFloat(e.buffer, point.Value)
+	e.buffer = append(e.buffer, '}')
+
+	return e.flush()
+}
+
+// WriteTextPoint - writes a text point of the started series
+func (e *ResultsEncoder) WriteTextPoint(point TextPoint) error {
+
+	if err := e.startPoint(); err != nil {
+		return err
+	}
+
+	text, err := json.Marshal(point.Text)
+	if err != nil {
+		return err
+	}
+
+	e.buffer = append(e.buffer, `{"timestamp":`...)
+	e.buffer = strconv.AppendInt(e.buffer, point.Timestamp, 10)
+	e.buffer = append(e.buffer, `,"text":`...)
+	e.buffer = append(e.buffer, text...)
+	e.buffer = append(e.buffer, '}')
+
+	return e.flush()
+}
+
+// EndSeries - ends the started series
+func (e *ResultsEncoder) EndSeries() error {
+
+	if e.closed {
+		return ErrEncoderClosed
+	}
+
+	if !e.started {
+		return ErrSeriesNotStarted
+	}
+
+	e.buffer = append(e.buffer[:0], "]}"...)
+
+	e.started = false
+	e.total++
+
+	return e.flush()
+}
+
+// EncodeNumberPoints - writes a whole number series
+func (e *ResultsEncoder) EncodeNumberPoints(points *NumberPoints) error {
+
+	if err := e.StartSeries(points.Metadata); err != nil {
+		return err
+	}
+
+	for _, point := range points.Values {
+		if err := e.WriteNumberPoint(point); err != nil {
+			return err
+		}
+	}
+
+	return e.EndSeries()
+}
+
+// EncodeTextPoints - writes a whole text series
+func (e *ResultsEncoder) EncodeTextPoints(points *TextPoints) error {
+
+	if err := e.StartSeries(points.Metadata); err != nil {
+		return err
+	}
+
+	for _, point := range points.Texts {
+		if err := e.WriteTextPoint(point); err != nil {
+			return err
+		}
+	}
+
+	return e.EndSeries()
+}
+
+// Close - ends the results writing the total, a started series must be ended before
+func (e *ResultsEncoder) Close() error {
+
+	if e.closed {
+		return ErrEncoderClosed
+	}
+
+	if e.started {
+		return ErrSeriesAlreadyStarted
+	}
+
+	e.buffer = e.buffer[:0]
+
+	if !e.opened {
+		e.buffer = append(e.buffer, `{"results":[`...)
+		e.opened = true
+	}
+
+	e.buffer = append(e.buffer, `],"total":`...)
+	e.buffer = strconv.AppendInt(e.buffer, int64(e.total), 10)
+
+	if e.next != "" {
+
+		next, err := json.Marshal(e.next)
+		if err != nil {
+			return err
+		}
+
+		e.buffer = append(e.buffer, `,"next":`...)
+		e.buffer = append(e.buffer, next...)
+	}
+
+	e.buffer = append(e.buffer, '}')
+
+	e.closed = true
+
+	return e.flush()
+}
+
+// Total - returns the number of series written
+func (e *ResultsEncoder) Total() int {
+	return e.total
+}
+
+// SetNext - sets the continuation token of the next page, written when the encoder is closed
+func (e *ResultsEncoder) SetNext(next string) {
+	e.next = next
+}
+
+func (e *ResultsEncoder) startPoint() error {
+
+	if e.closed {
+		return ErrEncoderClosed
+	}
+
+	if !e.started {
+		return ErrSeriesNotStarted
+	}
+
+	e.buffer = e.buffer[:0]
+
+	if e.points > 0 {
+		e.buffer = append(e.buffer, ',')
+	}
+
+	e.points++
+
+	return nil
+}
+
+func (e *ResultsEncoder) flush() error {
+	_, err := e.w.Write(e.buffer)
+	return err
+}
+
+// appendFloat - appends the float like encoding/json does
+func appendFloat(dst []byte, f float64) []byte {
+
+	abs := math.Abs(f)
+	format := byte('f')
+
+	if abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+
+	return strconv.AppendFloat(dst, f, format, -1, 64)
+}
+
+// ResultsDecoder - reads the raw query results written as {"results":[...],"total":N} one series at a time
+type ResultsDecoder struct {
+	decoder *json.Decoder
+	total   int
+	next    string
+	started bool
+	results bool
+	done    bool
+}
+
+// NewResultsDecoder - creates a results decoder reading from r
+func NewResultsDecoder(r io.Reader) *ResultsDecoder {
+	return &ResultsDecoder{
+		decoder: json.NewDecoder(r),
+	}
+}
+
+// NextNumberPoints - reads the next number series, returning io.EOF after the last one
+func (d *ResultsDecoder) NextNumberPoints(points *NumberPoints) error {
+
+	series := NumberPoints{}
+
+	if err := d.readSeries(&series); err != nil {
+		return err
+	}
+
+	*points = series
+
+	return nil
+}
+
+// NextTextPoints - reads the next text series, returning io.EOF after the last one
+func (d *ResultsDecoder) NextTextPoints(points *TextPoints) error {
+
+	series := TextPoints{}
+
+	if err := d.readSeries(&series); err != nil {
+		return err
+	}
+
+	*points = series
+
+	return nil
+}
+
+// Total - returns the total read from the results, available after io.EOF is returned
+func (d *ResultsDecoder) Total() int {
+	return d.total
+}
+
+// Next - returns the continuation token of the next page, available after io.EOF is returned
+func (d *ResultsDecoder) Next() string {
+	return d.next
+}
+
+func (d *ResultsDecoder) readSeries(series interface{}) error {
+
+	if d.done {
+		return io.EOF
+	}
+
+	if !d.started {
+
+		if err := d.expectDelim('{'); err != nil {
+			return err
+		}
+
+		if err := d.readFields(); err != nil {
+			return err
+		}
+
+		d.started = true
+	}
+
+	if d.done {
+		return io.EOF
+	}
+
+	if d.decoder.More() {
+		return d.decoder.Decode(series)
+	}
+
+	if err := d.expectDelim(']'); err != nil {
+		return err
+	}
+
+	d.done = true
+
+	if err := d.readFields(); err != nil {
+		return err
+	}
+
+	return io.EOF
+}
+
+// readFields - reads the object fields until the results array starts or the object ends,
+// null results are read as an empty array
+func (d *ResultsDecoder) readFields() error {
+
+	for d.decoder.More() {
+
+		token, err := d.decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		switch token {
+		case rawResultsParam:
+			if d.results {
+				return errors.New("duplicated results field")
+			}
+			d.results = true
+			token, err := d.decoder.Token()
+			if err != nil {
+				return err
+			}
+			if token == nil {
+				continue
+			}
+			if token != json.Delim('[') {
+				return fmt.Errorf("expected [ but found %v", token)
+			}
+			return nil
+		case rawTotalParam:
+			if err := d.decoder.Decode(&d.total); err != nil {
+				return err
+			}
+		case rawNextParam:
+			if err := d.decoder.Decode(&d.next); err != nil {
+				return err
+			}
+		default:
+			var skip json.RawMessage
+			if err := d.decoder.Decode(&skip); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := d.expectDelim('}'); err != nil {
+		return err
+	}
+
+	d.done = true
+
+	return nil
+}
+
+func (d *ResultsDecoder) expectDelim(delim json.Delim) error {
+
+	token, err := d.decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	if token != delim {
+		return fmt.Errorf("expected %s but found %v", delim, token)
+	}
+
+	return nil
+}
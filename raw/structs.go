@@ -2,6 +2,8 @@ package raw
 
 import (
 	"errors"
+
+	"github.com/uol/mycenae-shared/opentsdb"
 )
 
 //
@@ -18,10 +20,13 @@ type Metadata struct {
 // Query - the raw data query JSON
 type Query struct {
 	Metadata
-	Type         string `json:"type"`
-	Since        string `json:"since"`
-	Until        string `json:"until"`
-	EstimateSize bool   `json:"estimateSize"`
+	Type         string            `json:"type"`
+	Since        string            `json:"since"`
+	Until        string            `json:"until"`
+	EstimateSize bool              `json:"estimateSize"`
+	Limit        int               `json:"limit,omitempty"`
+	Cursor       string            `json:"cursor,omitempty"`
+	Filters      []opentsdb.Filter `json:"filters,omitempty"`
 }
 
 const (
@@ -32,6 +37,12 @@ const (
 	rawDataQuerySinceParam   string = "since"
 	rawDataQueryUntilParam   string = "until"
 	rawDataQueryEstimateSize string = "estimateSize"
+	rawDataQueryLimitParam   string = "limit"
+	rawDataQueryCursorParam  string = "cursor"
+	rawDataQueryFiltersParam string = "filters"
+	rawDataQueryFilterType   string = "type"
+	rawDataQueryFilterTagk   string = "tagk"
+	rawDataQueryFilterValue  string = "filter"
 	rawDataQueryTypeParam    string = "type"
 	rawDataQueryFunc         string = "Parse"
 	rawDataQueryKSID         string = "ksid"
@@ -44,6 +55,18 @@ var (
 
 	// ErrMissingMandatoryFields - mandatory fields are missing
 	ErrMissingMandatoryFields error = errors.New("mandatory fields are missing")
+
+	// ErrInvalidTimeRange - since or until are not valid times or since is not before until
+	ErrInvalidTimeRange error = errors.New("invalid time range")
+
+	// ErrInvalidLimit - the limit is not a positive number
+	ErrInvalidLimit error = errors.New("limit needs to be bigger than 0")
+
+	// ErrInvalidCursor - the cursor is not a token returned by a previous query
+	ErrInvalidCursor error = errors.New("invalid cursor")
+
+	// ErrInvalidFilter - the tag filter type, key or value is not valid
+	ErrInvalidFilter error = errors.New("invalid tag filter")
 )
 
 // NumberPoint - represents a raw number point result
@@ -74,10 +97,12 @@ type TextPoints struct {
 type NumberQueryResults struct {
 	Results []NumberPoints `json:"results"`
 	Total   int            `json:"total"`
+	Next    string         `json:"next,omitempty"`
 }
 
 // TextQueryResults - the final raw query text results
 type TextQueryResults struct {
 	Results []TextPoints `json:"results"`
 	Total   int          `json:"total"`
+	Next    string       `json:"next,omitempty"`
 }
@@ -0,0 +1,81 @@
+package raw
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/uol/mycenae-shared/opentsdb"
+)
+
+//
+// The raw query since and until resolution.
+//
+
+// ResolveSince - resolves since to an epoch in milliseconds, see ResolveTime
+func (dq *Query) ResolveSince(now time.Time) (int64, error) {
+
+	if dq.Since == "" {
+		return 0, errors.New("since is required")
+	}
+
+	return ResolveTime(dq.Since, now)
+}
+
+// ResolveUntil - resolves until to an epoch in milliseconds, see ResolveTime, now is used when not set
+func (dq *Query) ResolveUntil(now time.Time) (int64, error) {
+
+	if dq.Until == "" {
+		return now.UnixNano() / int64(time.Millisecond), nil
+	}
+
+	return ResolveTime(dq.Until, now)
+}
+
+// TimeRange - resolves since and until to epochs in milliseconds, since must be before until
+func (dq *Query) TimeRange(now time.Time) (since, until int64, err error) {
+
+	if since, err = dq.ResolveSince(now); err != nil {
+		return 0, 0, fmt.Errorf("invalid since: %s", err)
+	}
+
+	if until, err = dq.ResolveUntil(now); err != nil {
+		return 0, 0, fmt.Errorf("invalid until: %s", err)
+	}
+
+	if since > until {
+		return 0, 0, errors.New("since must be before until")
+	}
+
+	if since == until {
+		return 0, 0, errors.New("empty time range, since and until are the same")
+	}
+
+	return since, until, nil
+}
+
+// ResolveTime - resolves a time to an epoch in milliseconds, the time can be a duration before now like 1h
+// with the opentsdb relative units, an epoch with up to 10 digits in seconds or 13 digits in milliseconds,
+// or a RFC3339 date
+func ResolveTime(s string, now time.Time) (int64, error) {
+
+	if _, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return opentsdb.ParseTimeSpec(s, time.UTC, now)
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t.UnixNano() / int64(time.Millisecond), nil
+	}
+
+	if err := opentsdb.CheckDuration(s); err != nil {
+		return 0, fmt.Errorf("%s is not a duration, epoch or RFC3339 date: %s", s, err)
+	}
+
+	t, err := opentsdb.GetRelativeStart(now, s)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.UnixNano() / int64(time.Millisecond), nil
+}